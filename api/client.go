@@ -41,6 +41,9 @@ type RegistrationRequest struct {
 	Group string `json:"group"`
 	// Attributes associated with this identity
 	Attributes []Attribute `json:"attrs,omitempty"`
+	// CAName is the name of the CA to which the request is directed; the
+	// server's default CA if not set.  The caname header takes precedence.
+	CAName string `json:"caname,omitempty"`
 }
 
 // RegistrationResponse is a registration response
@@ -63,6 +66,11 @@ type EnrollmentRequest struct {
 	Label string `json:"label,omitempty"`
 	// CSR is Certificate Signing Request info
 	CSR *CSRInfo `json:"csr,omitempty"`
+	// AttrReqs are requests for attributes to add to the certificate
+	AttrReqs []*AttributeRequest `json:"attr_reqs,omitempty"`
+	// CAName is the name of the CA to which the request is directed; the
+	// server's default CA if not set.  The caname header takes precedence.
+	CAName string `json:"caname,omitempty"`
 }
 
 // ReenrollmentRequest is a request to reenroll an identity.
@@ -76,26 +84,60 @@ type ReenrollmentRequest struct {
 	Label string `json:"label,omitempty"`
 	// CSR is Certificate Signing Request info
 	CSR *CSRInfo `json:"csr,omitempty"`
+	// AttrReqs are requests for attributes to add to the certificate
+	AttrReqs []*AttributeRequest `json:"attr_reqs,omitempty"`
+	// CAName is the name of the CA to which the request is directed; the
+	// server's default CA if not set.  The caname header takes precedence.
+	CAName string `json:"caname,omitempty"`
 }
 
-// RevocationRequest is a revocation request for a single certificate or all certificates
-// associated with an identity.
+// RevocationRequest is a revocation request for a single certificate, all certificates
+// associated with an identity, or all identities and certificates in an affiliation.
 // To revoke a single certificate, both the Serial and AKI fields must be set;
-// otherwise, to revoke all certificates and the identity associated with an enrollment ID,
-// the Name field must be set to an existing enrollment ID.
+// to revoke all certificates and the identity associated with an enrollment ID,
+// the Name field must be set to an existing enrollment ID;
+// otherwise, to revoke all identities and certificates in an affiliation and all of its
+// sub-affiliations, the Affiliation field must be set to an existing affiliation.
 // A RevocationRequest can only be performed by a user with the "hf.Revoker" attribute.
 type RevocationRequest struct {
 	// Name of the identity whose certificates should be revoked
-	// If this field is omitted, then Serial and AKI must be specified.
+	// If this field is omitted, then Serial and AKI or Affiliation must be specified.
 	Name string `json:"id,omitempty"`
 	// Serial number of the certificate to be revoked
-	// If this is omitted, then Name must be specified
+	// If this is omitted, then Name or Affiliation must be specified
 	Serial string `json:"serial,omitempty"`
 	// AKI (Authority Key Identifier) of the certificate to be revoked
 	AKI string `json:"aki,omitempty"`
+	// Affiliation whose identities and certificates should be revoked, including
+	// those of all sub-affiliations (e.g. "org2" also revokes "org2.department1")
+	Affiliation string `json:"affiliation,omitempty"`
 	// Reason is the reason for revocation.  See https://godoc.org/golang.org/x/crypto/ocsp for
 	// valid values.  The default value is 0 (ocsp.Unspecified).
 	Reason int `json:"reason,omitempty"`
+	// DryRun if true returns what would be revoked without revoking anything
+	DryRun bool `json:"dryrun,omitempty"`
+	// CAName is the name of the CA to which the request is directed; the
+	// server's default CA if not set.  The caname header takes precedence.
+	CAName string `json:"caname,omitempty"`
+}
+
+// RevocationResponse is a summary of what was revoked by a RevocationRequest,
+// or what would have been revoked if DryRun was set in the request
+type RevocationResponse struct {
+	// Identities is the list of enrollment IDs which were disabled
+	Identities []string `json:"identities,omitempty"`
+	// RevokedCerts is the list of certificates which were revoked
+	RevokedCerts []RevokedCert `json:"revokedcerts,omitempty"`
+	// DryRun is true if nothing was actually revoked
+	DryRun bool `json:"dryrun,omitempty"`
+}
+
+// RevokedCert identifies a revoked certificate
+type RevokedCert struct {
+	// Serial number of the revoked certificate
+	Serial string `json:"serial"`
+	// AKI (Authority Key Identifier) of the revoked certificate
+	AKI string `json:"aki"`
 }
 
 // GetTCertBatchRequest is input provided to identity.GetTCertBatch
@@ -117,6 +159,9 @@ type GetTCertBatchRequest struct {
 	// cryptographically related to an ECert.  This may be necessary when using an
 	// HSM which does not support the TCert's key derivation function.
 	DisableKeyDerivation bool `json:"disable_kdf,omitempty"`
+	// CAName is the name of the CA to which the request is directed; the
+	// server's default CA if not set.  The caname header takes precedence.
+	CAName string `json:"caname,omitempty"`
 }
 
 // GetTCertBatchResponse is the return value of identity.GetTCertBatch
@@ -124,6 +169,25 @@ type GetTCertBatchResponse struct {
 	tcert.GetBatchResponse
 }
 
+// GetCAInfoRequest is a request for information about a CA, which is not
+// authenticated
+type GetCAInfoRequest struct {
+	// CAName is the name of the CA whose information is requested; the
+	// server's default CA if not set.  The caname header takes precedence.
+	CAName string `json:"caname,omitempty"`
+}
+
+// GetCAInfoResponse is the information about a CA
+type GetCAInfoResponse struct {
+	// CAName is the name of the CA
+	CAName string `json:"caname"`
+	// CAChain is the PEM-encoded certificate chain of the CA, which is
+	// returned after each certificate which it issues
+	CAChain []byte `json:"cachain"`
+	// Version is the version of the server
+	Version string `json:"version"`
+}
+
 // CSRInfo is Certificate Signing Request information
 type CSRInfo struct {
 	CN           string               `json:"CN"`
@@ -134,6 +198,26 @@ type CSRInfo struct {
 	SerialNumber string               `json:"serial_number,omitempty"`
 }
 
+// AttributeRequest is a request for an attribute of the identity to be
+// added to its enrollment certificate
+type AttributeRequest struct {
+	// Name is the name of the attribute
+	Name string `json:"name"`
+	// Require, if true, causes the request to fail if the identity does
+	// not have the attribute; otherwise the attribute is optional
+	Require bool `json:"require,omitempty"`
+}
+
+// GetName returns the name of the attribute
+func (ar *AttributeRequest) GetName() string {
+	return ar.Name
+}
+
+// IsRequired returns true if the attribute is required
+func (ar *AttributeRequest) IsRequired() bool {
+	return ar.Require
+}
+
 // Attribute is a name and value pair
 type Attribute struct {
 	Name  string `json:"name"`
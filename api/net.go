@@ -40,24 +40,40 @@ type RegistrationResponseNet struct {
 // EnrollmentRequestNet is a request to enroll an identity
 type EnrollmentRequestNet struct {
 	signer.SignRequest
+	// AttrReqs are requests for attributes to add to the certificate
+	AttrReqs []*AttributeRequest `json:"attr_reqs,omitempty"`
+	// CAName is the name of the CA to which the request is directed
+	CAName string `json:"caname,omitempty"`
 }
 
 // ReenrollmentRequestNet is a request to reenroll an identity.
 // This is useful to renew a certificate before it has expired.
 type ReenrollmentRequestNet struct {
 	signer.SignRequest
+	// AttrReqs are requests for attributes to add to the certificate
+	AttrReqs []*AttributeRequest `json:"attr_reqs,omitempty"`
+	// CAName is the name of the CA to which the request is directed
+	CAName string `json:"caname,omitempty"`
 }
 
 // RevocationRequestNet is a revocation request which flows over the network
 // to the fabric-ca server.
 // To revoke a single certificate, both the Serial and AKI fields must be set;
-// otherwise, to revoke all certificates and the identity associated with an enrollment ID,
-// the Name field must be set to an existing enrollment ID.
+// to revoke all certificates and the identity associated with an enrollment ID,
+// the Name field must be set to an existing enrollment ID;
+// otherwise, to revoke all identities and certificates in an affiliation,
+// the Affiliation field must be set to an existing affiliation.
 // A RevocationRequest can only be performed by a user with the "hf.Revoker" attribute.
 type RevocationRequestNet struct {
 	RevocationRequest
 }
 
+// RevocationResponseNet is a revocation response which flows over the network
+// from the fabric-ca server
+type RevocationResponseNet struct {
+	RevocationResponse
+}
+
 // GetTCertBatchRequestNet is a network request for a batch of transaction certificates
 type GetTCertBatchRequestNet struct {
 	GetTCertBatchRequest
@@ -72,6 +88,12 @@ type GetTCertBatchResponseNet struct {
 	tcert.GetBatchResponse
 }
 
+// GetCAInfoResponseNet is the network response to a request for information
+// about a CA
+type GetCAInfoResponseNet struct {
+	GetCAInfoResponse
+}
+
 // KeySig is a public key, signature, and signature algorithm tuple
 type KeySig struct {
 	// Key is a public key
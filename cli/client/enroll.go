@@ -88,8 +88,8 @@ func enrollMain(args []string, c cli.Config) error {
 		return fmt.Errorf("failed to store enrollment information: %s", err)
 	}
 
-	log.Infof("enrollment information was successfully stored in %s and %s",
-		client.GetMyKeyFile(), client.GetMyCertFile())
+	log.Infof("enrollment information was successfully stored in the MSP directory %s",
+		client.GetMSPDir())
 
 	return nil
 }
@@ -271,6 +271,7 @@ var endpoints = map[string]func() (http.Handler, error){
 	"reenroll": lib.NewReenrollHandler,
 	"revoke":   lib.NewRevokeHandler,
 	"tcert":    lib.NewTCertHandler,
+	"cainfo":   lib.NewCAInfoHandler,
 
 	// The remainder are the CFSSL endpoints
 	"sign": func() (http.Handler, error) {
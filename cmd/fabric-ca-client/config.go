@@ -78,18 +78,97 @@ const (
 # URL of the Fabric-ca-server (default: http://localhost:7054)
 serverURL: <<<URL>>>
 
+# URLs of further servers, such as the other servers of a cluster; requests
+# are sent to each server in turn, and a request is sent to the next server
+# when a server can not be reached
+urls:
+
+# Connections to the servers
+connection:
+   # Time allowed to connect to a server, including the TLS handshake
+   # (default: 10s)
+   connecttimeout: 10s
+   # Time allowed for a request, including reading the response (default: 60s)
+   requesttimeout: 60s
+   # Number of times a request which may safely be sent again, such as a
+   # getcacert request, is retried after a transient failure (default: 3)
+   retries: 3
+   # Time to wait before the first retry, which doubles after each retry
+   # (default: 500ms)
+   retryinterval: 500ms
+
+# Name of the CA to which requests are sent (default: the server's default CA)
+caname:
+
+# MSP directory in which enrollments are stored (default: msp)
+mspdir: msp
+
+# MSP directory in which enrollments made with the --tls option are stored
+# (default: tls-msp)
+tlsmspdir: tls-msp
+
+#############################################################################
+#  Crypto service provider (BCCSP) section, which configures the provider
+#  which generates and stores the client's keys and signs with them.
+#  Only the software provider ("sw") is currently supported.
 #############################################################################
-#    TLS section for the client's listenting port
+csp:
+   sw:
+      # Directory in which keys are stored, in files named by their SKI
+      # (default: the keystore directory of the MSP directory)
+      keystoredir:
+      # Hash family, SHA2 or SHA3 (default: SHA2)
+      hashfamily: SHA2
+      # Security level, 256 or 384 (default: 256)
+      securitylevel: 256
+
+#############################################################################
+#  Renewal section, which controls the renewal of the enrollment
+#  certificate by the "renew" command
+#############################################################################
+renew:
+   # Fraction of the certificate's lifetime after which it is renewed
+   # (default: 0.8)
+   fraction: 0.8
+   # Shell command run after the certificate is renewed; the name of the
+   # certificate file is in the FABRIC_CA_CLIENT_RENEWED_CERTFILE variable
+   hook:
+   # Time to wait before retrying a failed renewal, which doubles after
+   # each failure up to the maximum (defaults: 30s and 1h)
+   retryinterval: 30s
+   maxretryinterval: 1h
+
+#############################################################################
+#    TLS section for secure connections to the fabric-ca server
 #############################################################################
 tls:
-   # Enable TLS (default: false)
+   # Enable TLS (default: false).  If enabled, the server URL must be an https
+   # URL, and certfiles must verify the server's certificate.  An https URL
+   # without TLS enabled is verified with the system's root certificates.
+   # Setting certfiles or the client certificate without enabling TLS enables
+   # it, with a warning, for an https URL, and they are ignored for an http URL.
    enabled: false
+   # Overrides the server name sent to and verified against the server
+   servername:
+   # If true, the server's certificate is not verified, which is only safe
+   # for development (default: false)
+   insecureskipverify: false
 
-   # TLS for the client's listenting port (default: false)
+   # Root certificates used to verify the server's certificate, and the
+   # client's certificate and key for mutual TLS
    certfiles:
    client:
       certfile:
       keyfile:
+   # TLS versions (tls1.0, tls1.1, tls1.2 or tls1.3), cipher suites and curves
+   # (P-256, P-384, P-521 or X25519); Go's defaults apply to those not set.
+   # The "fips" preset selects TLS 1.2 with the FIPS approved ECDHE AES-GCM
+   # cipher suites and NIST curves.
+   preset:
+   minversion:
+   maxversion:
+   ciphersuites:
+   curves:
 
 #############################################################################
 #  Certificate Signing Request section for generating the CSR for
@@ -184,7 +263,12 @@ func createDefaultConfigFile() error {
 
 	// Do string subtitution to get the default config
 	cfg = strings.Replace(defaultCfgTemplate, "<<<URL>>>", fabricCAServerURL, 1)
-	cfg = strings.Replace(cfg, "<<<MYHOST>>>", myhost, 1)
+	if myhost != "" {
+		cfg = strings.Replace(cfg, "<<<MYHOST>>>", myhost, 1)
+	} else {
+		// No hosts are requested unless one is given
+		cfg = strings.Replace(cfg, "\n      - <<<MYHOST>>>", "", 1)
+	}
 
 	// Now write the file
 	err := os.MkdirAll(filepath.Dir(cfgFileName), 0755)
@@ -19,6 +19,7 @@ package main
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
 
 	"github.com/cloudflare/cfssl/log"
 	"github.com/hyperledger/fabric-ca/api"
@@ -29,6 +30,10 @@ import (
 
 var (
 	csrFile string
+	// Comma-separated list of attributes to add to the enrollment certificate
+	attrReqs string
+	// Store the enrollment in the TLS MSP directory
+	tlsEnrollment bool
 )
 
 // initCmd represents the init command
@@ -53,8 +58,17 @@ var enrollCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(enrollCmd)
+	enrollFlags := enrollCmd.Flags()
+	enrollFlags.StringVarP(&attrReqs, "attrs", "", "", attrReqsUsage)
+	enrollFlags.BoolVarP(&tlsEnrollment, "tls", "", false, tlsEnrollmentUsage)
 }
 
+const tlsEnrollmentUsage = "Store the enrollment in the TLS MSP directory, whose CA chain is stored " +
+	"in the tlscacerts and tlsintermediatecerts directories"
+
+const attrReqsUsage = "Comma-separated list of attributes to add to the certificate; " +
+	"the request fails if the identity does not have an attribute unless its name is followed by ':opt'"
+
 // The client enroll main logic
 func runEnroll() error {
 	log.Debug("Entered Enroll")
@@ -65,14 +79,16 @@ func runEnroll() error {
 	}
 
 	req := &api.EnrollmentRequest{
-		Name:   user,
-		Secret: pass,
+		Name:     user,
+		Secret:   pass,
+		AttrReqs: parseAttrReqs(attrReqs),
 	}
 
 	client := lib.Client{
 		HomeDir: filepath.Dir(cfgFileName),
 		Config:  clientCfg,
 	}
+	client.Config.TLSEnrollment = tlsEnrollment
 
 	ID, err := client.Enroll(req)
 	if err != nil {
@@ -84,8 +100,27 @@ func runEnroll() error {
 		return fmt.Errorf("Failed to store enrollment information: %s", err)
 	}
 
-	log.Infof("Enrollment information was successfully stored in %s and %s",
-		client.GetMyKeyFile(), client.GetMyCertFile())
+	log.Infof("Enrollment information was successfully stored in the MSP directory %s",
+		client.GetMSPDir())
 
 	return nil
 }
+
+// Parse a comma-separated list of attribute requests, where each attribute
+// is required unless its name is followed by ":opt"
+func parseAttrReqs(str string) []*api.AttributeRequest {
+	var reqs []*api.AttributeRequest
+	for _, name := range strings.Split(str, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		req := &api.AttributeRequest{Name: name, Require: true}
+		if strings.HasSuffix(name, ":opt") {
+			req.Name = strings.TrimSuffix(name, ":opt")
+			req.Require = false
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs
+}
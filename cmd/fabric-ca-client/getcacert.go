@@ -0,0 +1,76 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/cloudflare/cfssl/log"
+	"github.com/hyperledger/fabric-ca/api"
+	"github.com/hyperledger/fabric-ca/lib"
+	"github.com/spf13/cobra"
+)
+
+// getCACertCmd represents the getcacert command
+var getCACertCmd = &cobra.Command{
+	Use:   "getcacert -u http://serverAddr:serverPort",
+	Short: "Get CA certificate chain",
+	Long:  "Get the CA certificate chain from fabric-ca server and store it in the MSP's cacerts and intermediatecerts directories",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 0 {
+			cmd.Help()
+			return nil
+		}
+
+		err := runGetCACert()
+		if err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(getCACertCmd)
+}
+
+// The client getcacert main logic
+func runGetCACert() error {
+	log.Debug("Entered GetCACert")
+
+	client := lib.Client{
+		HomeDir: filepath.Dir(cfgFileName),
+		Config:  clientCfg,
+	}
+
+	resp, err := client.GetCAInfo(&api.GetCAInfoRequest{})
+	if err != nil {
+		return err
+	}
+
+	err = client.StoreCAChain(resp.CAChain)
+	if err != nil {
+		return fmt.Errorf("Failed to store CA certificate chain: %s", err)
+	}
+
+	log.Infof("CA certificate chain of CA '%s' (server version %s) was stored in %s",
+		resp.CAName, resp.Version, client.GetMSPDir())
+
+	return nil
+}
@@ -20,7 +20,6 @@ import (
 	"os"
 	"strings"
 
-	"github.com/cloudflare/cfssl/log"
 	"github.com/hyperledger/fabric-ca/util"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -56,18 +55,19 @@ func init() {
 
 	url := util.GetServerURL()
 
-	host, err := os.Hostname()
-	if err != nil {
-		log.Error(err)
-	}
-
 	// Set global flags used by all commands
 	pflags := rootCmd.PersistentFlags()
 	pflags.StringVarP(&cfgFileName, "config", "c", cfg, "Configuration file")
 	util.FlagString(pflags, "url", "u", url, "URL of the Fabric-ca server")
-	util.FlagString(pflags, "myhost", "m", host,
-		"Hostname to include in the certificate signing request during enrollment")
+	util.FlagString(pflags, "caname", "", "",
+		"Name of the CA to which requests are sent; the server's default CA if not set")
+	util.FlagString(pflags, "myhost", "m", "",
+		"Hostname to include in the certificate signing request during enrollment, "+
+			"which the identity's hf.AllowedHosts attribute must allow")
 	util.FlagBool(pflags, "debug", "d", false, "Enable debug logging")
+	pflags.Bool("insecure-skip-verify", false,
+		"Do not verify the server's TLS certificate, which is only safe for development")
+	viper.BindPFlag("tls.insecureskipverify", pflags.Lookup("insecure-skip-verify"))
 
 }
 
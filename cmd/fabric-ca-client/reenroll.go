@@ -50,6 +50,8 @@ func init() {
 	rootCmd.AddCommand(reenrollCmd)
 	reenrollFlags := reenrollCmd.Flags()
 	reenrollFlags.StringVarP(&csrFile, "csrfile", "f", "", "Certificate Signing Request information (Optional)")
+	reenrollFlags.StringVarP(&attrReqs, "attrs", "", "", attrReqsUsage)
+	reenrollFlags.BoolVarP(&tlsEnrollment, "tls", "", false, tlsEnrollmentUsage)
 
 }
 
@@ -61,13 +63,16 @@ func runReenroll() error {
 		HomeDir: filepath.Dir(cfgFileName),
 		Config:  clientCfg,
 	}
+	client.Config.TLSEnrollment = tlsEnrollment
 
 	id, err := client.LoadMyIdentity()
 	if err != nil {
 		return err
 	}
 
-	req := &api.ReenrollmentRequest{}
+	req := &api.ReenrollmentRequest{
+		AttrReqs: parseAttrReqs(attrReqs),
+	}
 
 	newID, err := id.Reenroll(req)
 	if err != nil {
@@ -79,8 +84,8 @@ func runReenroll() error {
 		return err
 	}
 
-	log.Infof("Enrollment information was successfully stored in %s and %s",
-		client.GetMyKeyFile(), client.GetMyCertFile())
+	log.Infof("Enrollment information was successfully stored in the MSP directory %s",
+		client.GetMSPDir())
 
 	return nil
 }
@@ -0,0 +1,95 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/cloudflare/cfssl/log"
+	"github.com/hyperledger/fabric-ca/api"
+	"github.com/hyperledger/fabric-ca/lib"
+	"github.com/spf13/cobra"
+)
+
+// renewCmd represents the renew command
+var renewCmd = &cobra.Command{
+	Use:   "renew",
+	Short: "Renew the enrollment certificate",
+	Long: "Reenroll with a new key, replace the certificate in the MSP directory and run the " +
+		"renewal hook.  With --daemon, keep running and renew the certificate each time the " +
+		"configured fraction of its lifetime has passed.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("Usage: too many arguments.\n%s", cmd.UsageString())
+		}
+		return runRenew()
+	},
+}
+
+var (
+	// renewDaemon is true to keep renewing the certificate when it is due
+	renewDaemon bool
+)
+
+func init() {
+	rootCmd.AddCommand(renewCmd)
+	renewFlags := renewCmd.Flags()
+	renewFlags.StringVarP(&attrReqs, "attrs", "", "", attrReqsUsage)
+	renewFlags.BoolVarP(&tlsEnrollment, "tls", "", false, tlsEnrollmentUsage)
+	renewFlags.BoolVarP(&renewDaemon, "daemon", "", false,
+		"Keep running and renew the certificate each time it is due")
+}
+
+// The client renew main logic
+func runRenew() error {
+	log.Debug("Entered Renew")
+
+	client := lib.Client{
+		HomeDir: filepath.Dir(cfgFileName),
+		Config:  clientCfg,
+	}
+	client.Config.TLSEnrollment = tlsEnrollment
+
+	req := &api.ReenrollmentRequest{
+		AttrReqs: parseAttrReqs(attrReqs),
+	}
+
+	if !renewDaemon {
+		return client.Renew(req)
+	}
+
+	// Renew until interrupted or terminated
+	stop := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case sig := <-sigs:
+			log.Infof("Received %s; stopping", sig)
+			close(stop)
+		case <-done:
+		}
+	}()
+	return client.RunRenewer(req, stop)
+}
@@ -55,6 +55,9 @@ func init() {
 	util.FlagString(revokeFlags, "serial", "s", "", "Serial Number")
 	util.FlagString(revokeFlags, "aki", "a", "", "AKI")
 	util.FlagString(revokeFlags, "reason", "r", "", "Reason for revoking")
+	util.FlagString(revokeFlags, "affiliation", "", "",
+		"Revoke all identities and certificates in this affiliation and its sub-affiliations")
+	util.FlagBool(revokeFlags, "dryrun", "", false, "Display what would be revoked without revoking anything")
 }
 
 // The client revoke main logic
@@ -76,16 +79,39 @@ func runRevoke() error {
 
 	serial := viper.GetString("serial")
 	aki := viper.GetString("aki")
+	affiliation := viper.GetString("affiliation")
 
-	if enrollmentID == "" && serial == "" {
-		return fmt.Errorf("Invalid usage; either ENROLLMENT_ID or both --serial and --aki are required")
+	if enrollmentID == "" && serial == "" && affiliation == "" {
+		return fmt.Errorf("Invalid usage; either ENROLLMENT_ID, both --serial and --aki, or --affiliation are required")
 	}
 
-	return id.Revoke(
+	resp, err := id.RevokeWithResponse(
 		&api.RevocationRequest{
-			Name:   enrollmentID,
-			Serial: serial,
-			AKI:    aki,
+			Name:        enrollmentID,
+			Serial:      serial,
+			AKI:         aki,
+			Affiliation: affiliation,
+			DryRun:      viper.GetBool("dryrun"),
 		})
+	if err != nil {
+		return err
+	}
+
+	printRevocationResponse(resp)
+	return nil
+}
 
+// Print a summary of what was revoked
+func printRevocationResponse(resp *api.RevocationResponse) {
+	verb := "Revoked"
+	if resp.DryRun {
+		verb = "Would revoke"
+	}
+	for _, name := range resp.Identities {
+		fmt.Printf("%s identity: %s\n", verb, name)
+	}
+	for _, cert := range resp.RevokedCerts {
+		fmt.Printf("%s certificate: serial=%s, aki=%s\n", verb, cert.Serial, cert.AKI)
+	}
+	fmt.Printf("%s %d identities and %d certificates\n", verb, len(resp.Identities), len(resp.RevokedCerts))
 }
@@ -0,0 +1,70 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/hyperledger/fabric-ca/lib"
+	"github.com/spf13/cobra"
+)
+
+// caCmd is the parent of the CA maintenance commands
+var caCmd = &cobra.Command{
+	Use:   "ca",
+	Short: "Manage the CAs hosted by the server",
+}
+
+// rolloverCmd represents the ca rollover command
+var rolloverCmd = &cobra.Command{
+	Use:   "rollover start|finish",
+	Short: "Roll over the key and certificate of a CA",
+	Long: "'start' generates a new key and certificate for the CA, cross-signed with the " +
+		"previous ones; certificates issued under the previous key remain valid until " +
+		"'finish' removes the previous key and certificate. Restart the server after each phase.",
+}
+
+// rolloverCAName is the name of the CA whose key is rolled over
+var rolloverCAName string
+
+func init() {
+	rolloverCmd.RunE = runRollover
+	rolloverCmd.Flags().StringVarP(&rolloverCAName, "caname", "", "",
+		"Name of the CA whose key is rolled over; the default CA if not specified")
+	caCmd.AddCommand(rolloverCmd)
+	rootCmd.AddCommand(caCmd)
+}
+
+// The ca rollover main logic
+func runRollover(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Usage: expecting one argument.\n%s", rolloverCmd.UsageString())
+	}
+	server := lib.Server{
+		HomeDir: filepath.Dir(cfgFileName),
+		Config:  serverCfg,
+	}
+	switch args[0] {
+	case "start":
+		return server.StartRollover(rolloverCAName)
+	case "finish":
+		return server.FinishRollover(rolloverCAName)
+	default:
+		return fmt.Errorf("Unknown rollover phase '%s'; expecting 'start' or 'finish'", args[0])
+	}
+}
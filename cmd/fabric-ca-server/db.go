@@ -0,0 +1,63 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/cloudflare/cfssl/log"
+	"github.com/hyperledger/fabric-ca/lib"
+	"github.com/spf13/cobra"
+)
+
+// dbCmd is the parent of the database maintenance commands
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: fmt.Sprintf("Manage the %s database", shortName),
+}
+
+// pruneCmd represents the db prune command
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Archive expired certificates",
+	Long: "Move certificates which have been expired for longer than the retention period " +
+		"from the certificates table to the archive table or file",
+}
+
+func init() {
+	pruneCmd.RunE = runPrune
+	dbCmd.AddCommand(pruneCmd)
+	rootCmd.AddCommand(dbCmd)
+}
+
+// The db prune main logic
+func runPrune(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("Usage: too many arguments.\n%s", pruneCmd.UsageString())
+	}
+	server := lib.Server{
+		HomeDir: filepath.Dir(cfgFileName),
+		Config:  serverCfg,
+	}
+	count, err := server.Prune()
+	if err != nil {
+		return err
+	}
+	log.Infof("Archived %d expired certificates", count)
+	return nil
+}
@@ -0,0 +1,138 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hyperledger/fabric-ca/lib"
+	"github.com/hyperledger/fabric-ca/lib/csp"
+	"github.com/hyperledger/fabric-ca/util"
+	"github.com/spf13/cobra"
+)
+
+// keystorePasswordEnvVar is the environment variable which sets the
+// password of the default CA's software key store
+const keystorePasswordEnvVar = envVarPrefix + "_CSP_SW_PASSWORD"
+
+// keystoreCmd is the parent of the key store maintenance commands
+var keystoreCmd = &cobra.Command{
+	Use:   "keystore",
+	Short: "Manage the key store of a CA's software crypto service provider",
+}
+
+// rekeyCmd represents the keystore rekey command
+var rekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Change the password with which the keys in a CA's key store are encrypted",
+	Long: "Re-encrypt the keys in the key store of a CA with a new password, which is read " +
+		"from the file given by --newpasswordfile or else prompted for. Set the new password " +
+		"in the CA's config before restarting the server.",
+}
+
+var (
+	// keystorePasswordPrompt is true to prompt for the key store password
+	keystorePasswordPrompt bool
+	// rekeyCAName is the name of the CA whose key store is rekeyed
+	rekeyCAName string
+	// rekeyPasswordFile is the file containing the new key store password
+	rekeyPasswordFile string
+)
+
+func init() {
+	rootCmd.PersistentFlags().BoolVarP(&keystorePasswordPrompt, "keystore-password-prompt", "", false,
+		"Prompt for the password of the default CA's software key store")
+	rekeyCmd.RunE = runRekey
+	flags := rekeyCmd.Flags()
+	flags.StringVarP(&rekeyCAName, "caname", "", "",
+		"Name of the CA whose key store is rekeyed; the default CA if not specified")
+	flags.StringVarP(&rekeyPasswordFile, "newpasswordfile", "", "",
+		"File containing the new password")
+	keystoreCmd.AddCommand(rekeyCmd)
+	rootCmd.AddCommand(keystoreCmd)
+}
+
+// Set the password of the default CA's software key store from the prompt
+// or the environment, which take precedence over the config file
+func setKeystorePassword(cfg *lib.ServerConfig) error {
+	var pwd string
+	if keystorePasswordPrompt {
+		buf, err := util.ReadPassword("Key store password: ")
+		if err != nil {
+			return err
+		}
+		pwd = string(buf)
+	} else {
+		pwd = os.Getenv(keystorePasswordEnvVar)
+	}
+	if pwd == "" {
+		return nil
+	}
+	if cfg.CSP == nil {
+		cfg.CSP = &csp.Config{SW: &csp.SWConfig{}}
+	}
+	if cfg.CSP.SW == nil {
+		return errors.New("A key store password was given but the software CSP is not configured")
+	}
+	cfg.CSP.SW.Password = pwd
+	return nil
+}
+
+// The keystore rekey main logic
+func runRekey(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("Usage: too many arguments.\n%s", rekeyCmd.UsageString())
+	}
+	newPassword, err := getNewKeystorePassword()
+	if err != nil {
+		return err
+	}
+	server := lib.Server{
+		HomeDir: filepath.Dir(cfgFileName),
+		Config:  serverCfg,
+	}
+	return server.RekeyKeystore(rekeyCAName, newPassword)
+}
+
+// Get the new key store password from the file, or else prompt for it twice
+func getNewKeystorePassword() ([]byte, error) {
+	if rekeyPasswordFile != "" {
+		buf, err := ioutil.ReadFile(rekeyPasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read new password file: %s", err)
+		}
+		return []byte(strings.TrimRight(string(buf), "\r\n")), nil
+	}
+	pwd, err := util.ReadPassword("New key store password: ")
+	if err != nil {
+		return nil, err
+	}
+	confirm, err := util.ReadPassword("Confirm new key store password: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(pwd, confirm) {
+		return nil, errors.New("The passwords do not match")
+	}
+	return pwd, nil
+}
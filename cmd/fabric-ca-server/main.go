@@ -41,6 +41,8 @@ var (
 		},
 	}
 	persistentFlags pflag.FlagSet
+	// parentURL is the URL of the parent server of an intermediate CA
+	parentURL string
 )
 
 func init() {
@@ -91,4 +93,6 @@ func registerCommonFlags(flags *pflag.FlagSet) {
 		"PEM-encoded key file used for TLS")
 	util.FlagString(flags, "tls.certfile", "", "cert.pem",
 		"PEM-encoded certificate file used for TLS")
+	flags.StringVarP(&parentURL, "parent-url", "", "",
+		"URL of the parent fabric-ca-server of an intermediate CA, including the enrollment ID and secret")
 }
@@ -18,6 +18,7 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"testing"
 )
@@ -69,6 +70,76 @@ func TestStart(t *testing.T) {
 	}
 }
 
+// TestDBPrune tests fabric-ca-server db prune
+func TestDBPrune(t *testing.T) {
+	err := RunMain([]string{cmdName, "db", "prune"})
+	if err != nil {
+		t.Errorf("server db prune failed: %s", err)
+	}
+	err = RunMain([]string{cmdName, "db", "prune", "extra"})
+	if err == nil {
+		t.Errorf("server db prune with extra argument should have failed")
+	}
+}
+
+// TestCARollover tests fabric-ca-server ca rollover
+func TestCARollover(t *testing.T) {
+	err := RunMain([]string{cmdName, "ca", "rollover", "finish"})
+	if err == nil {
+		t.Errorf("server ca rollover finish without a rollover in progress should have failed")
+	}
+	err = RunMain([]string{cmdName, "ca", "rollover", "start"})
+	if err != nil {
+		t.Errorf("server ca rollover start failed: %s", err)
+	}
+	err = RunMain([]string{cmdName, "ca", "rollover", "finish"})
+	if err != nil {
+		t.Errorf("server ca rollover finish failed: %s", err)
+	}
+	err = RunMain([]string{cmdName, "ca", "rollover", "bogus"})
+	if err == nil {
+		t.Errorf("server ca rollover with an unknown phase should have failed")
+	}
+	err = RunMain([]string{cmdName, "ca", "rollover"})
+	if err == nil {
+		t.Errorf("server ca rollover without a phase should have failed")
+	}
+}
+
+// TestKeystoreRekey tests fabric-ca-server keystore rekey
+func TestKeystoreRekey(t *testing.T) {
+	pwFile := "keystore.pw"
+	err := ioutil.WriteFile(pwFile, []byte("newpw\n"), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write password file: %s", err)
+	}
+	defer os.Remove(pwFile)
+	defer os.Unsetenv(keystorePasswordEnvVar)
+	err = RunMain([]string{cmdName, "keystore", "rekey", "--newpasswordfile", pwFile})
+	if err != nil {
+		t.Errorf("server keystore rekey failed: %s", err)
+	}
+	err = RunMain([]string{cmdName, "keystore", "rekey", "--newpasswordfile", pwFile})
+	if err == nil {
+		t.Errorf("server keystore rekey without the current password should have failed")
+	}
+	os.Setenv(keystorePasswordEnvVar, "newpw")
+	err = RunMain([]string{cmdName, "keystore", "rekey", "--newpasswordfile", pwFile})
+	if err != nil {
+		t.Errorf("server keystore rekey with the current password failed: %s", err)
+	}
+	err = RunMain([]string{cmdName, "keystore", "rekey", "--caname", "bogus", "--newpasswordfile", pwFile})
+	if err == nil {
+		t.Errorf("server keystore rekey of an unknown CA should have failed")
+	}
+	rekeyCAName = ""
+	err = RunMain([]string{cmdName, "keystore", "rekey", "--newpasswordfile", "bogus.pw"})
+	if err == nil {
+		t.Errorf("server keystore rekey with a missing password file should have failed")
+	}
+	rekeyPasswordFile = ""
+}
+
 // TestBogus tests a negative test case
 func TestBogus(t *testing.T) {
 	err := RunMain([]string{cmdName, "bogus"})
@@ -83,5 +154,10 @@ func TestClean(t *testing.T) {
 	os.Remove(testYaml)
 	os.Remove("ca-key.pem")
 	os.Remove("ca-cert.pem")
+	os.Remove("ca-chain.pem")
+	os.Remove("ca-key-previous.pem")
+	os.Remove("ca-cert-previous.pem")
+	os.Remove("ca-cross.pem")
 	os.Remove("fabric-ca-server.db")
+	os.RemoveAll("msp")
 }
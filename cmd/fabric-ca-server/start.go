@@ -18,8 +18,12 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 
+	"github.com/cloudflare/cfssl/log"
 	"github.com/hyperledger/fabric-ca/lib"
 	"github.com/hyperledger/fabric-ca/util"
 	"github.com/spf13/cobra"
@@ -47,14 +51,54 @@ func runStart(cmd *cobra.Command, args []string) error {
 	if len(args) > 0 {
 		return fmt.Errorf("Usage: too many arguments.\n%s", startCmd.UsageString())
 	}
-	server := lib.Server{
+	server := &lib.Server{
 		HomeDir:       filepath.Dir(cfgFileName),
 		Config:        serverCfg,
 		BlockingStart: blockingStart,
 	}
+	if blockingStart {
+		handleSignals(server)
+	}
 	err := server.Start()
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// Stop the server gracefully when the process is terminated or interrupted,
+// and reload its config from the config file on SIGHUP.  The blocking start
+// of the server returns once it has stopped.
+func handleSignals(server *lib.Server) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGHUP {
+				log.Info("Received signal 'hangup'; reloading the server's config")
+				err := reloadServer(server)
+				if err != nil {
+					log.Errorf("Failed to reload the server's config; the previous config is still in use: %s", err)
+				}
+				continue
+			}
+			log.Infof("Received signal '%s'; stopping the server", sig)
+			err := server.Stop()
+			if err != nil {
+				log.Errorf("Failed to stop the server: %s", err)
+				continue
+			}
+			signal.Stop(sigs)
+			return
+		}
+	}()
+}
+
+// Read the config file again and reload the config of the running server
+func reloadServer(server *lib.Server) error {
+	cfg, err := readServerConfig()
+	if err != nil {
+		return err
+	}
+	return server.Reload(cfg)
+}
@@ -0,0 +1,61 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/cloudflare/cfssl/log"
+	"github.com/hyperledger/fabric-ca/lib"
+	"github.com/spf13/cobra"
+)
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Sync the registry with the config file",
+	Long: "Add the identities and affiliations of the config file which are not yet in the " +
+		"registry of each CA, and update or report the registered identities which differ " +
+		"from the config file. Identities and affiliations are never deleted.",
+}
+
+func init() {
+	syncCmd.RunE = runSync
+	rootCmd.AddCommand(syncCmd)
+}
+
+// The sync main logic
+func runSync(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("Usage: too many arguments.\n%s", syncCmd.UsageString())
+	}
+	server := lib.Server{
+		HomeDir: filepath.Dir(cfgFileName),
+		Config:  serverCfg,
+	}
+	reports, err := server.Sync()
+	if err != nil {
+		return err
+	}
+	for _, report := range reports {
+		log.Infof("Synced CA '%s': added %d affiliations and %d identities, updated %d identities, "+
+			"%d differences not updated", report.CAName, len(report.AddedAffiliations),
+			len(report.AddedIdentities), len(report.UpdatedIdentities), len(report.Drift))
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+ * The attrmgr package contains utilities for managing the attributes of an
+ * identity which are embedded in its enrollment certificate (ECert).
+ * The attributes are stored as JSON in a non-critical X509 extension.
+ */
+
+package attrmgr
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+)
+
+var (
+	// AttrOID is the ASN.1 object identifier of the attribute extension
+	AttrOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+	// AttrOIDString is the string form of AttrOID
+	AttrOIDString = "1.2.3.4.5.6.7.8.1"
+)
+
+// AttributeRequest is a request for an attribute to be added to a certificate
+type AttributeRequest interface {
+	// GetName returns the name of the attribute
+	GetName() string
+	// IsRequired returns true if the request must fail when the identity
+	// does not have the attribute
+	IsRequired() bool
+}
+
+// AttributeGetter returns the value of an identity's attribute, or "" if the
+// identity does not have the attribute
+type AttributeGetter interface {
+	GetAttribute(name string) string
+}
+
+// Attributes are the attributes which are embedded in a certificate
+type Attributes struct {
+	Attrs map[string]string `json:"attrs"`
+}
+
+// ProcessAttributeRequests returns the requested attributes of an identity.
+// An error is returned if the identity does not have a required attribute;
+// optional attributes which the identity does not have are skipped.
+func ProcessAttributeRequests(reqs []AttributeRequest, getter AttributeGetter) (*Attributes, error) {
+	attrs := &Attributes{Attrs: map[string]string{}}
+	for _, req := range reqs {
+		name := req.GetName()
+		value := getter.GetAttribute(name)
+		if value == "" {
+			if req.IsRequired() {
+				return nil, fmt.Errorf("Attribute '%s' was requested but the identity does not have it", name)
+			}
+			continue
+		}
+		attrs.Attrs[name] = value
+	}
+	return attrs, nil
+}
+
+// Marshal returns the value of the attribute extension
+func (a *Attributes) Marshal() ([]byte, error) {
+	buf, err := json.Marshal(a)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal attributes: %s", err)
+	}
+	return buf, nil
+}
+
+// GetAttributesFromCert returns the attributes embedded in a certificate.
+// If the certificate has no attribute extension, no attributes are returned.
+func GetAttributesFromCert(cert *x509.Certificate) (*Attributes, error) {
+	attrs := &Attributes{Attrs: map[string]string{}}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(AttrOID) {
+			err := json.Unmarshal(ext.Value, attrs)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to unmarshal attributes from certificate: %s", err)
+			}
+			if attrs.Attrs == nil {
+				attrs.Attrs = map[string]string{}
+			}
+			break
+		}
+	}
+	return attrs, nil
+}
+
+// Names returns the names of the attributes
+func (a *Attributes) Names() []string {
+	names := make([]string, 0, len(a.Attrs))
+	for name := range a.Attrs {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Contains returns true if the attribute is present
+func (a *Attributes) Contains(name string) bool {
+	_, ok := a.Attrs[name]
+	return ok
+}
+
+// Value returns the value of an attribute and true, or "" and false if the
+// attribute is not present
+func (a *Attributes) Value(name string) (string, bool) {
+	value, ok := a.Attrs[name]
+	return value, ok
+}
+
+// Verify returns an error unless the attribute is present with the expected value
+func (a *Attributes) Verify(name, expected string) error {
+	value, ok := a.Attrs[name]
+	if !ok {
+		return fmt.Errorf("Attribute '%s' was not found", name)
+	}
+	if value != expected {
+		return fmt.Errorf("Attribute '%s' is '%s' but '%s' was expected", name, value, expected)
+	}
+	return nil
+}
+
+// True returns nil if the attribute is present with a value of "true"
+func (a *Attributes) True(name string) error {
+	return a.Verify(name, "true")
+}
@@ -0,0 +1,105 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attrmgr
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+)
+
+type attrReq struct {
+	name     string
+	required bool
+}
+
+func (ar *attrReq) GetName() string  { return ar.name }
+func (ar *attrReq) IsRequired() bool { return ar.required }
+
+type attrGetter map[string]string
+
+func (ag attrGetter) GetAttribute(name string) string { return ag[name] }
+
+func TestAttributes(t *testing.T) {
+	getter := attrGetter{"hf.Revoker": "true", "org": "org1"}
+
+	// A missing required attribute fails
+	_, err := ProcessAttributeRequests([]AttributeRequest{
+		&attrReq{name: "hf.Revoker", required: true},
+		&attrReq{name: "missing", required: true},
+	}, getter)
+	if err == nil {
+		t.Error("Request for a missing required attribute should have failed")
+	}
+
+	// A missing optional attribute is skipped
+	attrs, err := ProcessAttributeRequests([]AttributeRequest{
+		&attrReq{name: "hf.Revoker", required: true},
+		&attrReq{name: "org"},
+		&attrReq{name: "missing"},
+	}, getter)
+	if err != nil {
+		t.Fatalf("Failed to process attribute requests: %s", err)
+	}
+	buf, err := attrs.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal attributes: %s", err)
+	}
+
+	// Round trip the attributes through a certificate's extension
+	cert := &x509.Certificate{
+		Extensions: []pkix.Extension{{Id: AttrOID, Value: buf}},
+	}
+	attrs, err = GetAttributesFromCert(cert)
+	if err != nil {
+		t.Fatalf("Failed to get attributes from certificate: %s", err)
+	}
+	if len(attrs.Names()) != 2 {
+		t.Errorf("Expected 2 attributes but found %v", attrs.Names())
+	}
+	if err = attrs.True("hf.Revoker"); err != nil {
+		t.Errorf("hf.Revoker should have been true: %s", err)
+	}
+	if err = attrs.Verify("org", "org1"); err != nil {
+		t.Errorf("org should have been org1: %s", err)
+	}
+	if err = attrs.Verify("org", "org2"); err == nil {
+		t.Error("org should not have been org2")
+	}
+	if attrs.Contains("missing") {
+		t.Error("The missing attribute should not have been found")
+	}
+	if _, ok := attrs.Value("missing"); ok {
+		t.Error("The missing attribute should not have a value")
+	}
+
+	// A certificate without the extension has no attributes
+	attrs, err = GetAttributesFromCert(&x509.Certificate{})
+	if err != nil {
+		t.Fatalf("Failed to get attributes from certificate without extension: %s", err)
+	}
+	if len(attrs.Names()) != 0 {
+		t.Errorf("Expected no attributes but found %v", attrs.Names())
+	}
+
+	// A malformed extension fails
+	cert.Extensions[0].Value = []byte("bogus")
+	_, err = GetAttributesFromCert(cert)
+	if err == nil {
+		t.Error("Getting attributes from a malformed extension should have failed")
+	}
+}
@@ -0,0 +1,800 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/initca"
+	"github.com/cloudflare/cfssl/log"
+	"github.com/cloudflare/cfssl/signer"
+	"github.com/cloudflare/cfssl/signer/local"
+	"github.com/cloudflare/cfssl/signer/remote"
+	"github.com/hyperledger/fabric-ca/api"
+	libcsp "github.com/hyperledger/fabric-ca/lib/csp"
+	"github.com/hyperledger/fabric-ca/lib/dbutil"
+	"github.com/hyperledger/fabric-ca/lib/ldap"
+	"github.com/hyperledger/fabric-ca/lib/spi"
+	"github.com/hyperledger/fabric-ca/lib/tcert"
+	"github.com/hyperledger/fabric-ca/util"
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/viper"
+)
+
+// CA is a certificate authority hosted by the fabric-ca server.
+// Each CA has its own key material, database, user registry and
+// enrollment signer.
+type CA struct {
+	// The home directory of the CA
+	HomeDir string
+	// The CA's configuration
+	Config *CAConfig
+	// The database handle used to store certificates and optionally
+	// the user registry information, unless LDAP it enabled for the
+	// user registry function.
+	db *sqlx.DB
+	// The crypto service provider (BCCSP)
+	csp bccsp.BCCSP
+	// The certificate DB accessor
+	certDBAccessor *CertDBAccessor
+	// The user registry
+	registry spi.UserRegistry
+	// The signer used for enrollment
+	enrollSigner signer.Signer
+	// The CA's certificate, or nil if requests are not checked against it
+	cert *x509.Certificate
+	// The CA's previous certificate during a rollover of its key, or nil
+	previousCert *x509.Certificate
+	// The CA's certificate chain, which is returned by the cainfo endpoint
+	chain []byte
+	// The TCert manager and key tree, or nil if the CA can't issue TCerts
+	tcertMgr     *tcert.Mgr
+	tcertKeyTree *tcert.KeyTree
+}
+
+// newCAFromFile returns a CA whose config is read from a CA config file.
+// The CA's home directory is the directory containing the file.
+func newCAFromFile(file string) (*CA, error) {
+	v := viper.New()
+	v.SetConfigFile(file)
+	err := v.ReadInConfig()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read CA config file '%s': %s", file, err)
+	}
+	cfg := new(CAConfig)
+	err = v.Unmarshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("Incorrect format in CA config file '%s': %s", file, err)
+	}
+	if cfg.CA.Name == "" {
+		return nil, fmt.Errorf("No CA name was found in CA config file '%s'", file)
+	}
+	ca := &CA{HomeDir: filepath.Dir(file), Config: cfg}
+	err = ca.initConfig()
+	if err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+// Initialize the CA's key material, database, user registry and signer
+func (ca *CA) init(renew bool) (err error) {
+	// Initialize the Crypto Service Provider
+	err = ca.initCSP()
+	if err != nil {
+		log.Errorf("Failed to get the crypto service provider: %s", err)
+		return err
+	}
+	// Initialize key materials
+	err = ca.initKeyMaterial(renew)
+	if err != nil {
+		return err
+	}
+	// Initialize the database
+	err = ca.initDB()
+	if err != nil {
+		return err
+	}
+	// Add the configured identities and affiliations to the registry
+	_, err = ca.syncRegistry()
+	if err != nil {
+		return err
+	}
+	// Initialize the enrollment signer
+	err = ca.initEnrollmentSigner()
+	if err != nil {
+		return err
+	}
+	// Initialize TCert issuance, which is disabled for this CA on failure
+	err = ca.initTCert()
+	if err != nil {
+		log.Warningf("TCerts are disabled for CA '%s': %s", ca.Config.CA.Name, err)
+	}
+	return nil
+}
+
+// Set the defaults of the CA's config and make its file names absolute
+func (ca *CA) initConfig() error {
+	cfg := ca.Config
+	if cfg.CA.Certfile == "" {
+		cfg.CA.Certfile = "ca-cert.pem"
+	}
+	if cfg.CA.Keyfile == "" {
+		cfg.CA.Keyfile = "ca-key.pem"
+	}
+	if cfg.CA.Chainfile == "" {
+		cfg.CA.Chainfile = "ca-chain.pem"
+	}
+	if cfg.CA.Rollover.PreviousCertfile == "" {
+		cfg.CA.Rollover.PreviousCertfile = "ca-cert-previous.pem"
+	}
+	if cfg.CA.Rollover.PreviousKeyfile == "" {
+		cfg.CA.Rollover.PreviousKeyfile = "ca-key-previous.pem"
+	}
+	if cfg.CA.Rollover.Crossfile == "" {
+		cfg.CA.Rollover.Crossfile = "ca-cross.pem"
+	}
+	if cfg.Intermediate.Profile == "" {
+		cfg.Intermediate.Profile = "ca"
+	}
+	if cfg.CSR.CN == "" {
+		cfg.CSR.CN = "fabric-ca-server"
+	}
+	err := ca.makeFileNamesAbsolute()
+	if err != nil {
+		return err
+	}
+	return ca.initArchiveConfig()
+}
+
+// Make all file names in the CA's config absolute
+func (ca *CA) makeFileNamesAbsolute() error {
+	fields := []*string{
+		&ca.Config.CA.Certfile,
+		&ca.Config.CA.Keyfile,
+		&ca.Config.CA.Chainfile,
+		&ca.Config.CA.Rollover.PreviousCertfile,
+		&ca.Config.CA.Rollover.PreviousKeyfile,
+		&ca.Config.CA.Rollover.Crossfile,
+		&ca.Config.Intermediate.TLS.Client.CertFile,
+		&ca.Config.Intermediate.TLS.Client.KeyFile,
+	}
+	for i := range ca.Config.Intermediate.TLS.CertFiles {
+		fields = append(fields, &ca.Config.Intermediate.TLS.CertFiles[i])
+	}
+	ldapTLS := &ca.Config.LDAP.TLS
+	fields = append(fields, &ldapTLS.Client.CertFile, &ldapTLS.Client.KeyFile)
+	for i := range ldapTLS.CertFiles {
+		fields = append(fields, &ldapTLS.CertFiles[i])
+	}
+	if ca.Config.CSP != nil && ca.Config.CSP.SW != nil {
+		fields = append(fields, &ca.Config.CSP.SW.KeyStoreDir, &ca.Config.CSP.SW.PasswordFile)
+	}
+	return makeFileNamesAbsolute(fields, ca.HomeDir)
+}
+
+// Initialize the CA's crypto service provider (BCCSP), which generates and
+// holds the CA's keys.  Unless the key store directory is set in the CA's
+// config, keys are stored in the msp/keystore directory of the CA's home.
+func (ca *CA) initCSP() error {
+	if ca.csp != nil {
+		return nil
+	}
+	cfg, err := ca.getCSPConfig()
+	if err != nil {
+		return err
+	}
+	csp, err := libcsp.Get(cfg)
+	if err != nil {
+		return err
+	}
+	ca.csp = csp
+	return nil
+}
+
+// Get the config of the CA's crypto service provider, with defaults set
+func (ca *CA) getCSPConfig() (*libcsp.Config, error) {
+	// Each CA gets its own instance, which uses the CA's key store
+	cfg := &libcsp.Config{SW: &libcsp.SWConfig{}}
+	if ca.Config.CSP != nil {
+		if ca.Config.CSP.SW == nil {
+			return nil, errors.New("Invalid CSP configuration; must contain one of: 'sw'")
+		}
+		sw := *ca.Config.CSP.SW
+		cfg.SW = &sw
+	}
+	if cfg.SW.KeyStoreDir == "" {
+		cfg.SW.KeyStoreDir = filepath.Join(ca.HomeDir, "msp", "keystore")
+	}
+	cfg.SW.Ephemeral = true
+	return cfg, nil
+}
+
+// Initialize the fabric-ca server's key material
+func (ca *CA) initKeyMaterial(renew bool) error {
+	log.Debugf("Init CA with home %s and config %+v", ca.HomeDir, ca.Config)
+
+	keyFile := ca.Config.CA.Keyfile
+	certFile := ca.Config.CA.Certfile
+
+	// If we aren't renewing and the key and cert files exist, do nothing
+	if !renew {
+		// If they both exist, the server was already initialized
+		keyFileExists := util.FileExists(keyFile)
+		certFileExists := util.FileExists(certFile)
+		if keyFileExists && certFileExists {
+			log.Info("The CA key and certificate files already exist")
+			log.Infof("Key file location: %s", keyFile)
+			log.Infof("Certificate file location: %s", certFile)
+			err := ca.importKeyFile()
+			if err != nil {
+				return err
+			}
+			return ca.initChainFile()
+		}
+	}
+
+	cert, key, chain, err := ca.genKeyAndCert()
+	if err != nil {
+		return err
+	}
+	err = ca.storeKeyMaterial(key, cert, chain)
+	if err != nil {
+		return err
+	}
+	log.Info("The CA key and certificate files were generated")
+	return nil
+}
+
+// Generate a key in the CA's BCCSP and a certificate for the CA.  The
+// certificate is enrolled from the parent server if this is an intermediate
+// CA; otherwise a new root CA certificate is generated.  Returns the
+// certificate, the key and the chain which starts with the certificate.
+func (ca *CA) genKeyAndCert() (cert []byte, key bccsp.Key, chain []byte, err error) {
+	// Create the certificate request, copying from config
+	ptr := &ca.Config.CSR
+	req := csr.CertificateRequest{
+		CN:    ptr.CN,
+		Names: ptr.Names,
+		Hosts: ptr.Hosts,
+		// FIXME: NewBasicKeyRequest only does ecdsa 256; use config
+		KeyRequest:   csr.NewBasicKeyRequest(),
+		CA:           ptr.CA,
+		SerialNumber: ptr.SerialNumber,
+	}
+
+	key, cspSigner, err := libcsp.GenKey(ca.csp, req.KeyRequest)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("Failed to generate CA key: %s", err)
+	}
+
+	if ca.Config.Intermediate.ParentServer.URL != "" {
+		cert, chain, err = ca.getCACertFromParent(&req, cspSigner)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("Failed to initialize intermediate CA: %s", err)
+		}
+		return cert, key, chain, nil
+	}
+	cert, _, err = initca.NewFromSigner(&req, cspSigner)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("Failed to initialize CA [%s]\nRequest was %#v", err, req)
+	}
+	return cert, key, cert, nil
+}
+
+// Store the CA's certificate and chain to file.  The key is held by the
+// CA's BCCSP, so only a reference to it by its SKI is stored in the key file.
+func (ca *CA) storeKeyMaterial(key bccsp.Key, cert, chain []byte) error {
+	cfg := &ca.Config.CA
+	err := writeFile(cfg.Keyfile, libcsp.SKIToPEM(key), 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to store key: %s", err)
+	}
+	err = writeFile(cfg.Certfile, cert, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to store certificate: %s", err)
+	}
+	err = writeFile(cfg.Chainfile, chain, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to store certificate chain: %s", err)
+	}
+	log.Infof("Key file location: %s", cfg.Keyfile)
+	log.Infof("Certificate file location: %s", cfg.Certfile)
+	log.Infof("Certificate chain file location: %s", cfg.Chainfile)
+	return nil
+}
+
+// Import the key in the CA's key file into the CA's BCCSP if the file holds
+// a PEM-encoded key rather than a reference to a key in the BCCSP, and
+// replace the file's content with the reference
+func (ca *CA) importKeyFile() error {
+	keyFile := ca.Config.CA.Keyfile
+	buf, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return fmt.Errorf("Failed to read key: %s", err)
+	}
+	if libcsp.IsSKIFile(buf) {
+		return nil
+	}
+	key, err := libcsp.ImportPEMKey(ca.csp, buf)
+	if err != nil {
+		return fmt.Errorf("Failed to import the CA key in %s: %s", keyFile, err)
+	}
+	err = writeFile(keyFile, libcsp.SKIToPEM(key), 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to store key: %s", err)
+	}
+	log.Infof("Imported the CA key into the key store; %s now references it by its SKI", keyFile)
+	return nil
+}
+
+// Create the chain file of a root CA which was initialized before chain
+// files existed; the chain of a root CA is just its certificate
+func (ca *CA) initChainFile() error {
+	chainFile := ca.Config.CA.Chainfile
+	if util.FileExists(chainFile) {
+		log.Infof("Certificate chain file location: %s", chainFile)
+		return nil
+	}
+	if ca.Config.Intermediate.ParentServer.URL != "" {
+		return fmt.Errorf("The certificate chain file '%s' of the intermediate CA was not found", chainFile)
+	}
+	cert, err := ioutil.ReadFile(ca.Config.CA.Certfile)
+	if err != nil {
+		return fmt.Errorf("Failed to read certificate: %s", err)
+	}
+	err = writeFile(chainFile, cert, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to store certificate chain: %s", err)
+	}
+	log.Infof("Certificate chain file location: %s", chainFile)
+	return nil
+}
+
+// Initialize the database for the server
+func (ca *CA) initDB() error {
+	db := &ca.Config.DB
+
+	log.Debugf("Initializing '%s' data base at '%s'", db.Type, db.Datasource)
+
+	var err error
+
+	if db.Type == "" {
+		db.Type = "sqlite3"
+	}
+	if db.Datasource == "" {
+		var ds string
+		ds, err = util.MakeFileAbs("fabric-ca-server.db", ca.HomeDir)
+		if err != nil {
+			return err
+		}
+		db.Datasource = ds
+	}
+	switch db.Type {
+	case "sqlite3":
+		ca.db, _, err = dbutil.NewUserRegistrySQLLite3(db.Datasource)
+		if err != nil {
+			return err
+		}
+	case "postgres":
+		ca.db, _, err = dbutil.NewUserRegistryPostgres(db.Datasource, &db.TLS)
+		if err != nil {
+			return err
+		}
+	case "mysql":
+		ca.db, _, err = dbutil.NewUserRegistryMySQL(db.Datasource, &db.TLS)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Invalid db.type in config file: '%s'; must be 'sqlite3', 'postgres', or 'mysql'", db.Type)
+	}
+
+	// Set the certificate DB accessor
+	ca.certDBAccessor = NewCertDBAccessor(ca.db)
+
+	// Initialize the user registry.
+	// If LDAP is not configured, the fabric-ca server functions as a user
+	// registry based on the database.
+	err = ca.initUserRegistry()
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Initialized %s data base at %s", db.Type, db.Datasource)
+	return nil
+}
+
+// Close the CA's database and LDAP connection, and release its key store,
+// all of which are initialized again when the CA is
+func (ca *CA) close() error {
+	var err error
+	if lc, ok := ca.registry.(*ldap.Client); ok {
+		err = lc.Close()
+	}
+	ca.registry = nil
+	ca.certDBAccessor = nil
+	if ca.db != nil {
+		dberr := ca.db.Close()
+		if dberr != nil && err == nil {
+			err = fmt.Errorf("Failed to close the database: %s", dberr)
+		}
+		ca.db = nil
+	}
+	ca.csp = nil
+	return err
+}
+
+// Initialize the user registry interface
+func (ca *CA) initUserRegistry() error {
+	log.Debug("Initializing user registry")
+	var err error
+	ldapCfg := &ca.Config.LDAP
+
+	if ldapCfg.Enabled {
+		// Use LDAP for the user registry
+		ca.registry, err = ldap.NewClient(ldapCfg)
+		log.Debugf("Initialized LDAP user registry; err=%s", err)
+		return err
+	}
+
+	// Use the DB for the user registry
+	dbAccessor := new(Accessor)
+	dbAccessor.SetDB(ca.db)
+	ca.registry = dbAccessor
+	log.Debug("Initialized DB user registry")
+	return nil
+}
+
+// Initialize the enrollment signer
+func (ca *CA) initEnrollmentSigner() (err error) {
+
+	c := ca.Config
+
+	// Load the CA's certificate, which must have issued the certificates
+	// used to authenticate requests to this CA
+	cert, err := ioutil.ReadFile(c.CA.Certfile)
+	if err != nil {
+		return fmt.Errorf("Failed to read certificate: %s", err)
+	}
+	ca.cert, err = BytesToX509Cert(cert)
+	if err != nil {
+		return fmt.Errorf("Failed to parse certificate: %s", err)
+	}
+
+	ca.enrollSigner, err = ca.newEnrollmentSigner()
+	if err != nil {
+		return err
+	}
+
+	// Load the CA chain which is returned by the cainfo endpoint
+	ca.chain, err = ioutil.ReadFile(c.CA.Chainfile)
+	if err != nil {
+		return fmt.Errorf("Failed to read certificate chain: %s", err)
+	}
+
+	// During a rollover, the cross-signed and previous certificates are
+	// returned after the chain, and certificates issued under the previous
+	// key are still accepted
+	err = ca.initRollover()
+	if err != nil {
+		return err
+	}
+
+	// Successful enrollment
+	return nil
+}
+
+// newEnrollmentSigner returns a signer which signs with the CA's key, or
+// remotely, using the signing policy of the CA's config
+func (ca *CA) newEnrollmentSigner() (signer.Signer, error) {
+
+	c := ca.Config
+
+	// If there is a config, use its signing policy. Otherwise create a default policy.
+	var policy *config.Signing
+	if c.Signing != nil {
+		policy = c.Signing
+	} else {
+		policy = &config.Signing{
+			Profiles: map[string]*config.SigningProfile{},
+			Default:  config.DefaultConfig(),
+		}
+	}
+
+	// Add a profile for each identity type bound to signing profiles
+	policy, err := addIdentityTypeProfiles(policy, c.IdentityTypes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed initializing enrollment signer: %s", err)
+	}
+
+	// Allow the attribute extension to be added to certificates
+	policy = allowAttrsExtension(policy)
+
+	// Never copy the subject of a certificate from the CSR
+	policy = ignoreCSRSubject(policy)
+
+	// Make sure the policy reflects the new remote
+	if c.Remote != "" {
+		err = policy.OverrideRemotes(c.Remote)
+		if err != nil {
+			return nil, fmt.Errorf("Failed initializing enrollment signer: %s", err)
+		}
+	}
+
+	// Sign with the CA's key in the BCCSP, unless signing remotely
+	var enrollSigner signer.Signer
+	if c.Remote != "" {
+		enrollSigner, err = remote.NewSigner(policy)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		cspSigner, err := libcsp.GetSignerFromSKIFile(c.CA.Keyfile, ca.csp)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get the CA's signer: %s", err)
+		}
+		enrollSigner, err = local.NewSigner(cspSigner, ca.cert, signer.DefaultSigAlgo(cspSigner), policy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	enrollSigner.SetDBAccessor(ca.certDBAccessor)
+	return enrollSigner, nil
+}
+
+// loadUsersTable adds the configured users to the table if not already found
+func (ca *CA) loadUsersTable(report *SyncReport) error {
+	log.Debug("Loading users table")
+	registry := &ca.Config.Registry
+	for _, id := range registry.Identities {
+		log.Debugf("Loading identity '%s'", id.ID)
+		err := ca.addIdentity(&id, report)
+		if err != nil {
+			return err
+		}
+	}
+	log.Debug("Successfully loaded users table")
+	return nil
+}
+
+// loadAffiliationsTable adds the configured affiliations to the table
+func (ca *CA) loadAffiliationsTable(report *SyncReport) error {
+	log.Debug("Loading affiliations table")
+	err := ca.loadAffiliationsTableR(ca.Config.Affiliations, "", report)
+	if err == nil {
+		log.Debug("Successfully loaded affiliations table")
+	}
+	return err
+}
+
+// Recursive function to load the affiliations table hierarchy
+func (ca *CA) loadAffiliationsTableR(val interface{}, parentPath string, report *SyncReport) (err error) {
+	var path string
+	if val == nil {
+		return nil
+	}
+	switch val.(type) {
+	case string:
+		path = affiliationPath(val.(string), parentPath)
+		err = ca.addAffiliation(path, parentPath, report)
+		if err != nil {
+			return err
+		}
+	case []string:
+		for _, ele := range val.([]string) {
+			err = ca.loadAffiliationsTableR(ele, parentPath, report)
+			if err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, ele := range val.([]interface{}) {
+			err = ca.loadAffiliationsTableR(ele, parentPath, report)
+			if err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for name, ele := range val.(map[string]interface{}) {
+			path = affiliationPath(name, parentPath)
+			err = ca.addAffiliation(path, parentPath, report)
+			if err != nil {
+				return err
+			}
+			err = ca.loadAffiliationsTableR(ele, path, report)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("Invalid affiliation '%v' under '%s'", val, parentPath)
+	}
+	return nil
+}
+
+// Add an identity to the registry, or sync it with its config if it is
+// already registered
+func (ca *CA) addIdentity(id *ServerConfigIdentity, report *SyncReport) error {
+	info, err := ca.registry.GetUserInfo(id.ID)
+	if err == nil {
+		return ca.syncIdentity(id, &info, report)
+	}
+	maxEnrollments, err := ca.getMaxEnrollments(id.MaxEnrollments)
+	if err != nil {
+		return err
+	}
+	rec := spi.UserInfo{
+		Name:           id.ID,
+		Pass:           id.Pass,
+		Type:           id.Type,
+		Group:          id.Affiliation,
+		Attributes:     ca.convertAttrs(id.Attributes),
+		MaxEnrollments: maxEnrollments,
+	}
+	err = ca.registry.InsertUser(rec)
+	if err != nil {
+		return fmt.Errorf("Failed to insert user '%s': %s", id.ID, err)
+	}
+	log.Debugf("Registered identity: %+v", id)
+	report.AddedIdentities = append(report.AddedIdentities, id.ID)
+	return nil
+}
+
+// Add an affiliation to the registry unless it is already there
+func (ca *CA) addAffiliation(path, parentPath string, report *SyncReport) error {
+	_, err := ca.registry.GetGroup(path)
+	if err == nil {
+		log.Debugf("Loaded affiliation %s", path)
+		return nil
+	}
+	log.Debugf("Adding affiliation %s", path)
+	err = ca.registry.InsertGroup(path, parentPath)
+	if err != nil {
+		return err
+	}
+	report.AddedAffiliations = append(report.AddedAffiliations, path)
+	return nil
+}
+
+func (ca *CA) convertAttrs(inAttrs map[string]string) []api.Attribute {
+	outAttrs := make([]api.Attribute, 0)
+	for name, value := range inAttrs {
+		outAttrs = append(outAttrs, api.Attribute{
+			Name:  name,
+			Value: value,
+		})
+	}
+	return outAttrs
+}
+
+// Get max enrollments relative to the configured max
+func (ca *CA) getMaxEnrollments(requestedMax int) (int, error) {
+	configuredMax := ca.Config.Registry.MaxEnrollments
+	if requestedMax < 0 {
+		return configuredMax, nil
+	}
+	if configuredMax == 0 {
+		// no limit, so grant any request
+		return requestedMax, nil
+	}
+	if requestedMax == 0 && configuredMax != 0 {
+		return 0, fmt.Errorf("Infinite enrollments is not permitted; max is %d",
+			configuredMax)
+	}
+	if requestedMax > configuredMax {
+		return 0, fmt.Errorf("Max enrollments of %d is not permitted; max is %d",
+			requestedMax, configuredMax)
+	}
+	return requestedMax, nil
+}
+
+// Initialize the TCert manager and key tree of the CA
+func (ca *CA) initTCert() error {
+	log.Debug("Initializing TCert manager")
+	mgr, err := ca.loadTCertMgr()
+	if err != nil {
+		return err
+	}
+	// FIXME: The root prekey must be stored persistently in DB and retrieved here if not found
+	rootKey, err := libcsp.GenRootKey(ca.csp)
+	if err != nil {
+		return err
+	}
+	ca.tcertMgr = mgr
+	ca.tcertKeyTree = tcert.NewKeyTree(ca.csp, rootKey)
+	return nil
+}
+
+// Load the TCert manager, which signs with the CA's key in the BCCSP if the
+// CA's key file references it, or else with the PEM key in the key file
+func (ca *CA) loadTCertMgr() (*tcert.Mgr, error) {
+	keyFile := ca.Config.CA.Keyfile
+	certFile := ca.Config.CA.Certfile
+	buf, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read key: %s", err)
+	}
+	if !libcsp.IsSKIFile(buf) {
+		return tcert.LoadMgr(keyFile, certFile)
+	}
+	signer, err := libcsp.GetSignerFromSKIFile(keyFile, ca.csp)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tcert.LoadCert(certFile)
+	if err != nil {
+		return nil, err
+	}
+	return tcert.NewMgr(signer, cert)
+}
+
+// CertDBAccessor returns the accessor of the CA's certificate database
+func (ca *CA) CertDBAccessor() *CertDBAccessor {
+	return ca.certDBAccessor
+}
+
+// checkIssuer returns an error if the CA's certificate is known and did not
+// issue cert, so that an identity of one CA can't make requests of another.
+// During a rollover, a certificate issued by the CA's previous certificate,
+// if it has not expired, is also accepted.
+func (ca *CA) checkIssuer(cert *x509.Certificate) error {
+	if ca.cert == nil {
+		return nil
+	}
+	err := cert.CheckSignatureFrom(ca.cert)
+	if err != nil && ca.previousCert != nil && time.Now().Before(ca.previousCert.NotAfter) {
+		err = cert.CheckSignatureFrom(ca.previousCert)
+	}
+	if err != nil {
+		return fmt.Errorf("The certificate was not issued by CA '%s': %s", ca.Config.CA.Name, err)
+	}
+	return nil
+}
+
+// userHasAttribute returns nil if the user has the attribute, or an
+// appropriate error if the user does not have this attribute.
+func (ca *CA) userHasAttribute(username, attrname string) error {
+	val, err := ca.getUserAttrValue(username, attrname)
+	if err != nil {
+		return err
+	}
+	if val == "" {
+		return fmt.Errorf("user '%s' does not have attribute '%s'", username, attrname)
+	}
+	return nil
+}
+
+// getUserAttrValue returns a user's value for an attribute
+func (ca *CA) getUserAttrValue(username, attrname string) (string, error) {
+	log.Debugf("getUserAttrValue user=%s, attr=%s", username, attrname)
+	user, err := ca.registry.GetUser(username, []string{attrname})
+	if err != nil {
+		return "", err
+	}
+	attrval := user.GetAttribute(attrname)
+	log.Debugf("getUserAttrValue user=%s, name=%s, value=%s", username, attrname, attrval)
+	return attrval, nil
+}
@@ -17,19 +17,25 @@ limitations under the License.
 package lib
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/cloudflare/cfssl/certdb"
 	certsql "github.com/cloudflare/cfssl/certdb/sql"
 	"github.com/cloudflare/cfssl/log"
+	"github.com/hyperledger/fabric-ca/lib/dbutil"
 	"github.com/hyperledger/fabric-ca/util"
 	"github.com/kisielk/sqlstruct"
 
 	"github.com/jmoiron/sqlx"
 )
 
+// The columns of the certificates and certificates_archive tables
+const certColumns = "id, serial_number, authority_key_identifier, ca_label, status, reason, expiry, revoked_at, pem"
+
 const (
 	insertSQL = `
 INSERT INTO certificates (id, serial_number, authority_key_identifier, ca_label, status, reason, expiry, revoked_at, pem)
@@ -39,10 +45,29 @@ INSERT INTO certificates (id, serial_number, authority_key_identifier, ca_label,
 SELECT %s FROM certificates
 WHERE (id = ?);`
 
+	selectBySerialSQL = `
+SELECT %s FROM certificates
+WHERE (serial_number = ? AND authority_key_identifier = ?);`
+
 	updateRevokeSQL = `
 UPDATE certificates
 SET status='revoked', revoked_at=CURRENT_TIMESTAMP, reason=:reason
 WHERE (id = :id AND status != 'revoked');`
+
+	// Certificates which expired before the first parameter, or before the
+	// second parameter if they are revoked, may be archived
+	archivableWhereSQL = `
+WHERE (expiry < ? AND (status != 'revoked' OR expiry < ?))`
+
+	selectArchivableSQL = `
+SELECT %s FROM certificates` + archivableWhereSQL
+
+	insertArchiveSQL = `
+INSERT INTO certificates_archive (` + certColumns + `)
+SELECT ` + certColumns + ` FROM certificates` + archivableWhereSQL
+
+	deleteArchivableSQL = `
+DELETE FROM certificates` + archivableWhereSQL
 )
 
 // CertRecord extends CFSSL CertificateRecord by adding an enrollment ID to the record
@@ -147,6 +172,23 @@ func (d *CertDBAccessor) GetCertificate(serial, aki string) (crs []certdb.Certif
 	return crs, nil
 }
 
+// GetCertificateWithID gets a CertRecord, including the enrollment ID of the
+// certificate's owner, indexed by serial and AKI.
+func (d *CertDBAccessor) GetCertificateWithID(serial, aki string) (crs []CertRecord, err error) {
+	log.Debugf("DB: Get certificate by serial (%s) and AKI (%s)", serial, aki)
+	err = d.checkDB()
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.db.Select(&crs, fmt.Sprintf(d.db.Rebind(selectBySerialSQL), sqlstruct.Columns(CertRecord{})), serial, aki)
+	if err != nil {
+		return nil, err
+	}
+
+	return crs, nil
+}
+
 // GetUnexpiredCertificates gets all unexpired certificate from db.
 func (d *CertDBAccessor) GetUnexpiredCertificates() (crs []certdb.CertificateRecord, err error) {
 	crs, err = d.accessor.GetUnexpiredCertificates()
@@ -162,17 +204,21 @@ func (d *CertDBAccessor) RevokeCertificatesByID(id string, reasonCode int) (crs
 	if err != nil {
 		return nil, err
 	}
+	return revokeCertificatesByIDTx(d.db, id, reasonCode)
+}
 
+// revokeCertificatesByIDTx is RevokeCertificatesByID as part of transaction 'tx'
+func revokeCertificatesByIDTx(tx sqlx.Ext, id string, reasonCode int) (crs []CertRecord, err error) {
 	var record = new(CertRecord)
 	record.ID = id
 	record.Reason = reasonCode
 
-	err = d.db.Select(&crs, d.db.Rebind("SELECT * FROM certificates WHERE (id = ? AND status != 'revoked')"), id)
+	err = sqlx.Select(tx, &crs, tx.Rebind("SELECT * FROM certificates WHERE (id = ? AND status != 'revoked')"), id)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = d.db.NamedExec(updateRevokeSQL, record)
+	_, err = sqlx.NamedExec(tx, updateRevokeSQL, record)
 	if err != nil {
 		return nil, err
 	}
@@ -180,6 +226,124 @@ func (d *CertDBAccessor) RevokeCertificatesByID(id string, reasonCode int) (crs
 	return crs, err
 }
 
+// ArchiveExpiredCertificates moves the certificates which expired before
+// 'expiredBefore' out of the certificates table.  Revoked certificates are only
+// moved if they also expired before 'revokedBefore'.  If 'archiveFile' is empty,
+// the certificates are moved to the certificates_archive table; otherwise, each
+// is appended to 'archiveFile' as a line of JSON.  The lines are synced to disk
+// before the certificates are deleted, and removed again if the deletion fails.
+// Returns the number of certificates moved.
+func (d *CertDBAccessor) ArchiveExpiredCertificates(expiredBefore, revokedBefore time.Time, archiveFile string) (int, error) {
+	log.Debugf("DB: Archive certificates which expired before %s (revoked before %s)", expiredBefore, revokedBefore)
+	err := d.checkDB()
+	if err != nil {
+		return 0, err
+	}
+
+	expiredBefore = expiredBefore.UTC()
+	revokedBefore = revokedBefore.UTC()
+
+	if archiveFile == "" {
+		err = dbutil.CreateArchiveTable(d.db)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	tx, err := d.db.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to begin transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	var crs []CertRecord
+	err = tx.Select(&crs, fmt.Sprintf(tx.Rebind(selectArchivableSQL), sqlstruct.Columns(CertRecord{})), expiredBefore, revokedBefore)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get expired certificates: %s", err)
+	}
+	if len(crs) == 0 {
+		return 0, nil
+	}
+
+	committed := false
+	if archiveFile == "" {
+		_, err = tx.Exec(tx.Rebind(insertArchiveSQL), expiredBefore, revokedBefore)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to insert into certificates_archive table: %s", err)
+		}
+	} else {
+		var archive *os.File
+		var size int64
+		archive, size, err = exportCertificates(archiveFile, crs)
+		if err != nil {
+			return 0, err
+		}
+		defer func() {
+			// Remove the appended certificates unless the transaction
+			// was committed
+			if !committed {
+				archive.Truncate(size)
+			}
+			archive.Close()
+		}()
+	}
+
+	res, err := tx.Exec(tx.Rebind(deleteArchivableSQL), expiredBefore, revokedBefore)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to delete expired certificates: %s", err)
+	}
+	numRowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if int(numRowsAffected) != len(crs) {
+		return 0, fmt.Errorf("Expected to archive %d certificates but %d were deleted", len(crs), numRowsAffected)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to commit transaction: %s", err)
+	}
+	committed = true
+
+	return len(crs), nil
+}
+
+// exportCertificates appends each of the certificates to the archive file as
+// a line of JSON and syncs it.  Returns the open archive file and its size
+// before the certificates were appended.
+func exportCertificates(archiveFile string, crs []CertRecord) (*os.File, int64, error) {
+	archive, err := os.OpenFile(archiveFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Failed to open certificate archive file: %s", err)
+	}
+	info, err := archive.Stat()
+	if err != nil {
+		archive.Close()
+		return nil, 0, fmt.Errorf("Failed to stat certificate archive file '%s': %s", archiveFile, err)
+	}
+	size := info.Size()
+	err = writeArchive(archive, crs)
+	if err != nil {
+		archive.Truncate(size)
+		archive.Close()
+		return nil, 0, fmt.Errorf("Failed to export certificates to '%s': %s", archiveFile, err)
+	}
+	return archive, size, nil
+}
+
+// Append the certificates to the archive file and sync it
+func writeArchive(archive *os.File, crs []CertRecord) error {
+	enc := json.NewEncoder(archive)
+	for _, cr := range crs {
+		err := enc.Encode(cr)
+		if err != nil {
+			return fmt.Errorf("certificate %s: %s", cr.Serial, err)
+		}
+	}
+	return archive.Sync()
+}
+
 // RevokeCertificate updates a certificate with a given serial number and marks it revoked.
 func (d *CertDBAccessor) RevokeCertificate(serial, aki string, reasonCode int) error {
 	err := d.accessor.RevokeCertificate(serial, aki, reasonCode)
@@ -18,8 +18,12 @@ package lib
 
 import (
 	"bytes"
+	"context"
+	"crypto"
 	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -30,14 +34,16 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 
 	cfsslapi "github.com/cloudflare/cfssl/api"
 	"github.com/cloudflare/cfssl/csr"
 	"github.com/cloudflare/cfssl/log"
 	"github.com/cloudflare/cfssl/signer"
 	"github.com/hyperledger/fabric-ca/api"
-	"github.com/hyperledger/fabric-ca/lib/tls"
+	"github.com/hyperledger/fabric-ca/lib/csp"
 	"github.com/hyperledger/fabric-ca/util"
+	"github.com/hyperledger/fabric/bccsp"
 )
 
 const (
@@ -97,61 +103,160 @@ type Client struct {
 
 	// The client's configuration
 	Config *ClientConfig
+
+	// The crypto service provider which generates and stores the client's
+	// keys and signs with them, and the directory of its key store
+	csp         bccsp.BCCSP
+	cspKeyStore string
+
+	// The HTTP client which is reused for all requests, and the index of
+	// the server to which the next request is sent first
+	httpClient      *http.Client
+	httpClientMutex sync.Mutex
+	nextServer      uint32
 }
 
 // Enroll enrolls a new identity
 // @param req The enrollment request
 func (c *Client) Enroll(req *api.EnrollmentRequest) (*Identity, error) {
+	return c.EnrollContext(context.Background(), req)
+}
+
+// EnrollContext enrolls a new identity.  The request to the server is
+// canceled when the context is canceled or its deadline expires.
+// @param ctx The context of the request
+// @param req The enrollment request
+func (c *Client) EnrollContext(ctx context.Context, req *api.EnrollmentRequest) (*Identity, error) {
 	log.Debugf("Enrolling %+v", req)
 
-	// Generate the CSR
-	csrPEM, key, err := c.GenCSR(req.CSR, req.Name)
+	// Generate the key and CSR
+	csrPEM, key, err := c.GenCSRWithBCCSP(req.CSR, req.Name)
 	if err != nil {
 		log.Debugf("enroll failure generating CSR: %s", err)
 		return nil, err
 	}
 
-	// Get the body of the request
-	sreq := signer.SignRequest{
-		Hosts:   signer.SplitHosts(req.Hosts),
-		Request: string(csrPEM),
-		Profile: req.Profile,
-		Label:   req.Label,
-	}
-	body, err := util.Marshal(sreq, "SignRequest")
+	result, err := c.enroll(ctx, req, csrPEM)
 	if err != nil {
 		return nil, err
 	}
 
-	// Send the CSR to the fabric-ca server with basic auth header
-	post, err := c.NewPost("enroll", body)
+	// Create an identity from the key and certificate in the response
+	return c.newIdentityFromResponse(ctx, result, req.Name, key, req.CAName)
+}
+
+// GetCAInfo returns the name, certificate chain and server version of a CA.
+// The request is not authenticated.
+// @param req The request, which selects the CA by name
+func (c *Client) GetCAInfo(req *api.GetCAInfoRequest) (*api.GetCAInfoResponse, error) {
+	return c.GetCAInfoContext(context.Background(), req)
+}
+
+// GetCAInfoContext returns the name, certificate chain and server version
+// of a CA.  The request to the server is canceled when the context is
+// canceled or its deadline expires.
+// @param ctx The context of the request
+// @param req The request, which selects the CA by name
+func (c *Client) GetCAInfoContext(ctx context.Context, req *api.GetCAInfoRequest) (*api.GetCAInfoResponse, error) {
+	log.Debugf("Getting CA info %+v", req)
+	body, err := util.Marshal(req, "GetCAInfoRequest")
+	if err != nil {
+		return nil, err
+	}
+	post, err := c.NewPostContext(ctx, "cainfo", body)
 	if err != nil {
 		return nil, err
 	}
-	post.SetBasicAuth(req.Name, req.Secret)
 	result, err := c.SendPost(post)
 	if err != nil {
 		return nil, err
 	}
+	// Convert the generic result into a GetCAInfoResponse
+	buf, err := util.Marshal(result, "GetCAInfoResponse")
+	if err != nil {
+		return nil, err
+	}
+	resp := &api.GetCAInfoResponseNet{}
+	err = util.Unmarshal(buf, resp, "GetCAInfoResponse")
+	if err != nil {
+		return nil, err
+	}
+	return &resp.GetCAInfoResponse, nil
+}
 
-	// Create an identity from the key and certificate in the response
-	return c.newIdentityFromResponse(result, req.Name, key)
+// enroll sends an enrollment request for the CSR and returns the result
+func (c *Client) enroll(ctx context.Context, req *api.EnrollmentRequest, csrPEM []byte) (interface{}, error) {
+	// Get the body of the request
+	reqNet := &api.EnrollmentRequestNet{
+		SignRequest: signer.SignRequest{
+			Hosts:   signer.SplitHosts(req.Hosts),
+			Request: string(csrPEM),
+			Profile: req.Profile,
+			Label:   req.Label,
+		},
+		AttrReqs: req.AttrReqs,
+		CAName:   req.CAName,
+	}
+	body, err := util.Marshal(reqNet, "SignRequest")
+	if err != nil {
+		return nil, err
+	}
+
+	// Send the CSR to the fabric-ca server with basic auth header
+	post, err := c.NewPostContext(ctx, "enroll", body)
+	if err != nil {
+		return nil, err
+	}
+	post.SetBasicAuth(req.Name, req.Secret)
+	return c.SendPost(post)
 }
 
-// newIdentityFromResponse returns an Identity for enroll and reenroll responses
+// newIdentityFromResponse returns an Identity for enroll and reenroll responses,
+// along with the chain of the CA which issued its certificate
+// @param ctx The context of the enroll or reenroll request
 // @param result The result from server
 // @param id Name of identity being enrolled or reenrolled
-// @param key The private key which was used to sign the request
-func (c *Client) newIdentityFromResponse(result interface{}, id string, key []byte) (*Identity, error) {
+// @param key The key of the identity
+// @param caName The name of the CA which issued the certificate
+func (c *Client) newIdentityFromResponse(ctx context.Context, result interface{}, id string, key bccsp.Key, caName string) (*Identity, error) {
 	log.Debugf("newIdentityFromResponse %s", id)
-	certByte, err := base64.StdEncoding.DecodeString(result.(string))
+	cert, err := parseCertResponse(result)
+	if err != nil {
+		return nil, err
+	}
+	identity := newIdentity(c, id, key, cert)
+	// The CA chain is not returned with the certificate, so get it from the
+	// cainfo endpoint; the identity is still usable without it
+	info, err := c.GetCAInfoContext(ctx, &api.GetCAInfoRequest{CAName: caName})
+	if err != nil {
+		log.Warningf("Failed to get the CA chain of enrolled identity '%s': %s", id, err)
+	} else {
+		identity.caChain = info.CAChain
+	}
+	return identity, nil
+}
+
+// parseCertResponse returns the certificate in an enroll or reenroll response
+func parseCertResponse(result interface{}) ([]byte, error) {
+	str, ok := result.(string)
+	if !ok {
+		return nil, errors.New("Invalid response format from server: the result is not a string")
+	}
+	certByte, err := base64.StdEncoding.DecodeString(str)
 	if err != nil {
 		return nil, fmt.Errorf("Invalid response format from server: %s", err)
 	}
-	return newIdentity(c, id, key, certByte), nil
+	block, _ := pem.Decode(certByte)
+	if block == nil {
+		return nil, errors.New("Invalid response format from server: no certificate was found")
+	}
+	return pem.EncodeToMemory(block), nil
 }
 
 // GenCSR generates a CSR (Certificate Signing Request)
+//
+// Deprecated: the key is generated in software and returned in PEM form
+// rather than held by the client's BCCSP; use GenCSRWithBCCSP instead.
 func (c *Client) GenCSR(req *api.CSRInfo, id string) ([]byte, []byte, error) {
 	log.Debugf("GenCSR %+v", req)
 
@@ -167,6 +272,83 @@ func (c *Client) GenCSR(req *api.CSRInfo, id string) ([]byte, []byte, error) {
 	return csrPEM, key, nil
 }
 
+// GenCSRWithBCCSP generates a key in the client's BCCSP and a CSR
+// (Certificate Signing Request) signed by it, and returns the CSR and the key
+func (c *Client) GenCSRWithBCCSP(req *api.CSRInfo, id string) ([]byte, bccsp.Key, error) {
+	log.Debugf("GenCSRWithBCCSP %+v", req)
+
+	cr := c.newCertificateRequest(req)
+	cr.CN = id
+
+	key, cspSigner, err := c.genKey(cr.KeyRequest)
+	if err != nil {
+		log.Debugf("failed generating key: %s", err)
+		return nil, nil, err
+	}
+
+	csrPEM, err := csr.Generate(cspSigner, cr)
+	if err != nil {
+		log.Debugf("failed generating CSR: %s", err)
+		return nil, nil, err
+	}
+
+	return csrPEM, key, nil
+}
+
+// genKey generates a key in the client's BCCSP for the key request, which
+// is an ECDSA P-256 key by default, and returns the key and a signer for it
+func (c *Client) genKey(req csr.KeyRequest) (bccsp.Key, crypto.Signer, error) {
+	bccspInst, err := c.GetCSP()
+	if err != nil {
+		return nil, nil, err
+	}
+	return csp.GenKey(bccspInst, req)
+}
+
+// GetCSP returns the client's BCCSP.  Unless the key store directory is
+// set in the client's config, keys are stored in the keystore directory of
+// the client's MSP, in files named by their SKI (Subject Key Identifier).
+func (c *Client) GetCSP() (bccsp.BCCSP, error) {
+	cfg := &csp.Config{SW: &csp.SWConfig{}}
+	if c.Config.CSP != nil {
+		if c.Config.CSP.SW == nil {
+			return nil, errors.New("Invalid client CSP configuration; must contain one of: 'sw'")
+		}
+		sw := *c.Config.CSP.SW
+		cfg.SW = &sw
+		// A relative password file is relative to the client's home
+		file, err := util.MakeFileAbs(sw.PasswordFile, c.HomeDir)
+		if err != nil {
+			return nil, err
+		}
+		cfg.SW.PasswordFile = file
+	}
+	if cfg.SW.KeyStoreDir == "" {
+		cfg.SW.KeyStoreDir = path.Join(c.GetMSPDir(), mspKeystoreDir)
+	} else {
+		// A relative key store directory is relative to the client's home
+		dir, err := util.MakeFileAbs(cfg.SW.KeyStoreDir, c.HomeDir)
+		if err != nil {
+			return nil, err
+		}
+		cfg.SW.KeyStoreDir = dir
+	}
+	// The default key store changes with the MSP directory, which
+	// depends on whether this is a TLS enrollment
+	if c.csp != nil && c.cspKeyStore == cfg.SW.KeyStoreDir {
+		return c.csp, nil
+	}
+	// Each client gets its own instance, which uses the client's key store
+	cfg.SW.Ephemeral = true
+	bccspInst, err := csp.Get(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to initialize the client's CSP: %s", err)
+	}
+	c.csp = bccspInst
+	c.cspKeyStore = cfg.SW.KeyStoreDir
+	return c.csp, nil
+}
+
 // newCertificateRequest creates a certificate request which is used to generate
 // a CSR (Certificate Signing Request)
 func (c *Client) newCertificateRequest(req *api.CSRInfo) *csr.CertificateRequest {
@@ -174,15 +356,10 @@ func (c *Client) newCertificateRequest(req *api.CSRInfo) *csr.CertificateRequest
 	if req != nil && req.Names != nil {
 		cr.Names = req.Names
 	}
+	// No hosts are requested by default, since the server rejects hosts
+	// which the identity is not entitled to
 	if req != nil && req.Hosts != nil {
 		cr.Hosts = req.Hosts
-	} else {
-		// Default requested hosts are local hostname
-		hostname, _ := os.Hostname()
-		if hostname != "" {
-			cr.Hosts = make([]string, 1)
-			cr.Hosts[0] = hostname
-		}
 	}
 	if req != nil && req.KeyRequest != nil {
 		cr.KeyRequest = req.KeyRequest
@@ -194,62 +371,56 @@ func (c *Client) newCertificateRequest(req *api.CSRInfo) *csr.CertificateRequest
 	return &cr
 }
 
-// LoadMyIdentity loads the client's identity from disk
-func (c *Client) LoadMyIdentity() (*Identity, error) {
-	return c.LoadIdentity(c.GetMyKeyFile(), c.GetMyCertFile())
-}
-
-// StoreMyIdentity stores my identity to disk
-func (c *Client) StoreMyIdentity(key, cert []byte) error {
-	err := util.WriteFile(c.GetMyKeyFile(), key, 0600)
+// LoadIdentity loads an identity from disk
+//
+// Deprecated: the key in the PEM key file is imported into the client's
+// BCCSP; use LoadIdentityFromCert, which finds the key in the BCCSP, instead.
+func (c *Client) LoadIdentity(keyFile, certFile string) (*Identity, error) {
+	key, err := util.ReadFile(keyFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return util.WriteFile(c.GetMyCertFile(), cert, 0644)
-}
-
-// GetMyKeyFile returns the path to this identity's key file
-func (c *Client) GetMyKeyFile() string {
-	file := os.Getenv("FABRIC_CA_KEY_FILE")
-	if file == "" {
-		file = path.Join(c.GetMyEnrollmentDir(), "key.pem")
+	cert, err := util.ReadFile(certFile)
+	if err != nil {
+		return nil, err
 	}
-	return file
+	return c.NewIdentity(key, cert)
 }
 
-// GetMyCertFile returns the path to this identity's certificate file
-func (c *Client) GetMyCertFile() string {
-	file := os.Getenv("FABRIC_CA_CERT_FILE")
-	if file == "" {
-		file = path.Join(c.GetMyEnrollmentDir(), "cert.pem")
+// LoadIdentityFromCert loads an identity from a certificate file.  The
+// identity's key is the key in the client's BCCSP whose SKI is that of the
+// certificate.
+func (c *Client) LoadIdentityFromCert(certFile string) (*Identity, error) {
+	cert, err := util.ReadFile(certFile)
+	if err != nil {
+		return nil, err
 	}
-	return file
-}
-
-// GetMyEnrollmentDir returns the path to this identity's enrollment directory
-func (c *Client) GetMyEnrollmentDir() string {
-	dir := os.Getenv("FABRIC_CA_ENROLLMENT_DIR")
-	if dir == "" {
-		dir = c.HomeDir
+	key, err := c.getKey(cert)
+	if err != nil {
+		return nil, err
 	}
-	return dir
+	return c.NewIdentityWithKey(key, cert)
 }
 
-// LoadIdentity loads an identity from disk
-func (c *Client) LoadIdentity(keyFile, certFile string) (*Identity, error) {
-	key, err := util.ReadFile(keyFile)
+// NewIdentity creates a new identity
+//
+// Deprecated: the PEM key is imported into the client's BCCSP; use
+// NewIdentityWithKey with a key held by the BCCSP instead.
+func (c *Client) NewIdentity(key, cert []byte) (*Identity, error) {
+	bccspInst, err := c.GetCSP()
 	if err != nil {
 		return nil, err
 	}
-	cert, err := util.ReadFile(certFile)
+	bccspKey, err := csp.ImportPEMKey(bccspInst, key)
 	if err != nil {
 		return nil, err
 	}
-	return c.NewIdentity(key, cert)
+	return c.NewIdentityWithKey(bccspKey, cert)
 }
 
-// NewIdentity creates a new identity
-func (c *Client) NewIdentity(key, cert []byte) (*Identity, error) {
+// NewIdentityWithKey creates a new identity whose key is held by the
+// client's BCCSP
+func (c *Client) NewIdentityWithKey(key bccsp.Key, cert []byte) (*Identity, error) {
 	name, err := util.GetEnrollmentIDFromPEM(cert)
 	if err != nil {
 		return nil, err
@@ -257,6 +428,31 @@ func (c *Client) NewIdentity(key, cert []byte) (*Identity, error) {
 	return newIdentity(c, name, key, cert), nil
 }
 
+// getKey returns the key in the client's BCCSP whose SKI is that of the
+// certificate
+func (c *Client) getKey(cert []byte) (bccsp.Key, error) {
+	x509Cert, err := BytesToX509Cert(cert)
+	if err != nil {
+		return nil, err
+	}
+	csp, err := c.GetCSP()
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := csp.KeyImport(x509Cert, &bccsp.X509PublicKeyImportOpts{Temporary: true})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get SKI of certificate: %s", err)
+	}
+	key, err := csp.GetKey(pubKey.SKI())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find the key of the certificate: %s", err)
+	}
+	if !key.Private() {
+		return nil, errors.New("Failed to find the private key of the certificate")
+	}
+	return key, nil
+}
+
 // LoadCSRInfo reads CSR (Certificate Signing Request) from a file
 // @parameter path The path to the file contains CSR info in JSON format
 func (c *Client) LoadCSRInfo(path string) (*api.CSRInfo, error) {
@@ -274,35 +470,38 @@ func (c *Client) LoadCSRInfo(path string) (*api.CSRInfo, error) {
 
 // NewPost create a new post request
 func (c *Client) NewPost(endpoint string, reqBody []byte) (*http.Request, error) {
+	return c.NewPostContext(context.Background(), endpoint, reqBody)
+}
+
+// NewPostContext creates a new post request with a context, which cancels
+// the request when it is canceled or its deadline expires
+func (c *Client) NewPostContext(ctx context.Context, endpoint string, reqBody []byte) (*http.Request, error) {
 	curl, cerr := c.getURL(endpoint)
 	if cerr != nil {
 		return nil, cerr
 	}
-	req, err := http.NewRequest("POST", curl, bytes.NewReader(reqBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", curl, bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, fmt.Errorf("Failed posting to %s: %s", curl, err)
 	}
+	if c.Config.CAName != "" {
+		req.Header.Set(caNameHdrName, c.Config.CAName)
+	}
 	return req, nil
 }
 
-// SendPost sends a request to the LDAP server and returns a response
+// SendPost sends a request to the fabric-ca server and returns a response.
+// If more than one server is configured, the request is sent to the first
+// of them which can be reached.  An error reported by the server is
+// returned as a *ServerError.  The request is canceled when its context is
+// canceled or its deadline expires.
 func (c *Client) SendPost(req *http.Request) (interface{}, error) {
 	reqStr := util.HTTPRequestToString(req)
 	log.Debugf("Sending request\n%s", reqStr)
 
-	tlsConfig, err := tls.GetClientTLSConfig(&c.Config.TLS)
+	resp, err := c.sendRequest(req, reqStr)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to get client TLS config [%s]; not sending\n%s", err, reqStr)
-	}
-
-	tr := &http.Transport{
-		TLSClientConfig: tlsConfig,
-	}
-
-	httpClient := &http.Client{Transport: tr}
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("POST failure [%s]; not sending\n%s", err, reqStr)
+		return nil, err
 	}
 	var respBody []byte
 	if resp.Body != nil {
@@ -313,21 +512,27 @@ func (c *Client) SendPost(req *http.Request) (interface{}, error) {
 		}
 		log.Debugf("Received response\n%s", util.HTTPResponseToString(resp))
 	}
+	// Errors reported by the server are returned as a ServerError
+	scode := resp.StatusCode
 	var body *cfsslapi.Response
 	if respBody != nil && len(respBody) > 0 {
 		body = new(cfsslapi.Response)
 		err = json.Unmarshal(respBody, body)
-		if err != nil {
+		if err != nil && scode < 400 {
 			return nil, fmt.Errorf("Failed to parse response [%s] for request:\n%s", err, reqStr)
 		}
-		if len(body.Errors) > 0 {
-			msg := body.Errors[0].Message
-			return nil, fmt.Errorf("Error response from server was '%s' for request:\n%s", msg, reqStr)
+		if err == nil && len(body.Errors) > 0 {
+			msg := body.Errors[0]
+			log.Debugf("Error response from server was '%s' (code: %d) for request:\n%s", msg.Message, msg.Code, reqStr)
+			return nil, &ServerError{Code: msg.Code, StatusCode: scode, Message: msg.Message}
 		}
 	}
-	scode := resp.StatusCode
 	if scode >= 400 {
-		return nil, fmt.Errorf("Failed with server status code %d for request:\n%s", scode, reqStr)
+		log.Debugf("Failed with server status code %d for request:\n%s", scode, reqStr)
+		return nil, &ServerError{
+			StatusCode: scode,
+			Message:    fmt.Sprintf("Failed with server status code %d", scode),
+		}
 	}
 	if body == nil {
 		return nil, nil
@@ -340,7 +545,12 @@ func (c *Client) SendPost(req *http.Request) (interface{}, error) {
 }
 
 func (c *Client) getURL(endpoint string) (string, error) {
-	nurl, err := NormalizeURL(c.Config.URL)
+	return getServerURL(c.Config.URL, endpoint)
+}
+
+// getServerURL returns the URL of an endpoint of the server
+func getServerURL(server, endpoint string) (string, error) {
+	nurl, err := NormalizeURL(server)
 	if err != nil {
 		return "", err
 	}
@@ -18,11 +18,17 @@ package lib_test
 
 import (
 	"bytes"
+	"context"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -238,6 +244,373 @@ func TestSendBadPost(t *testing.T) {
 	}
 }
 
+func TestClientFailover(t *testing.T) {
+	var hits, conns int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Write([]byte(`{"success":true,"result":{"CAName":"ca1"}}`))
+	}))
+	ts.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	// Requests fail over from the unreachable server to the running one,
+	// whichever of them each request is first sent to
+	c := &Client{Config: &ClientConfig{URL: getUnreachableURL(t), URLs: []string{ts.URL}}}
+	for i := 0; i < 4; i++ {
+		resp, err := c.GetCAInfo(&api.GetCAInfoRequest{})
+		if err != nil {
+			t.Fatalf("Failed to fail over to the running server: %s", err)
+		}
+		if resp.CAName != "ca1" {
+			t.Errorf("Unexpected CA name: %s", resp.CAName)
+		}
+	}
+	if hits != 4 {
+		t.Errorf("Expected 4 requests to the running server but found %d", hits)
+	}
+	if conns != 1 {
+		t.Errorf("Expected the connection to be reused but %d connections were made", conns)
+	}
+
+	// Without a running server, the request fails
+	c = &Client{Config: &ClientConfig{URL: getUnreachableURL(t)}}
+	c.Config.Connection.RetryInterval = time.Millisecond
+	_, err := c.GetCAInfo(&api.GetCAInfoRequest{})
+	if err == nil {
+		t.Error("Request to an unreachable server should have failed")
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	c := &Client{Config: &ClientConfig{URL: ts.URL}}
+	c.Config.Connection.Retries = 2
+	c.Config.Connection.RetryInterval = time.Millisecond
+
+	// Idempotent requests are retried
+	_, err := c.GetCAInfo(&api.GetCAInfoRequest{})
+	if err == nil {
+		t.Error("Request to an unavailable server should have failed")
+	}
+	if hits != 3 {
+		t.Errorf("Expected an idempotent request to be sent 3 times but it was sent %d times", hits)
+	}
+
+	// Other requests are sent once
+	hits = 0
+	_, err = c.Enroll(&api.EnrollmentRequest{Name: "admin", Secret: "adminpw"})
+	if err == nil {
+		t.Error("Enroll with an unavailable server should have failed")
+	}
+	if hits != 1 {
+		t.Errorf("Expected an enroll request to be sent once but it was sent %d times", hits)
+	}
+	os.RemoveAll("msp")
+}
+
+func TestClientRetrySucceeds(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server is unavailable, then resets the connection, and then
+		// answers the third attempt
+		switch atomic.AddInt32(&hits, 1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.(*net.TCPConn).SetLinger(0)
+				conn.Close()
+			}
+		default:
+			w.Write([]byte(`{"success":true,"result":{"CAName":"ca1"}}`))
+		}
+	}))
+	defer ts.Close()
+
+	c := &Client{Config: &ClientConfig{URL: ts.URL}}
+	c.Config.Connection.Retries = 2
+	c.Config.Connection.RetryInterval = time.Millisecond
+	resp, err := c.GetCAInfo(&api.GetCAInfoRequest{})
+	if err != nil {
+		t.Fatalf("Retried GetCAInfo failed: %s", err)
+	}
+	if resp.CAName != "ca1" {
+		t.Errorf("Unexpected CA name: %s", resp.CAName)
+	}
+	if hits != 3 {
+		t.Errorf("Expected the request to be sent 3 times but it was sent %d times", hits)
+	}
+}
+
+func TestClientClosedConnection(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first request is answered, so that its connection is reused;
+		// the server then closes the connection after reading each request
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.Write([]byte(`{"success":true,"result":{"CAName":"ca1"}}`))
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer ts.Close()
+
+	c := &Client{Config: &ClientConfig{URL: ts.URL}}
+	c.Config.Connection.Retries = -1
+	_, err := c.GetCAInfo(&api.GetCAInfoRequest{})
+	if err != nil {
+		t.Fatalf("GetCAInfo failed: %s", err)
+	}
+
+	// An enroll request which the server may have handled is not resent
+	_, err = c.Enroll(&api.EnrollmentRequest{Name: "admin", Secret: "adminpw"})
+	if err == nil {
+		t.Error("Enroll on a connection closed by the server should have failed")
+	}
+	if hits != 2 {
+		t.Errorf("Expected an enroll request to be sent once but it was sent %d times", hits-1)
+	}
+
+	// An idempotent request is resent once on a new connection
+	hits = 1
+	_, err = c.GetCAInfo(&api.GetCAInfoRequest{})
+	if err == nil {
+		t.Error("GetCAInfo on a connection closed by the server should have failed")
+	}
+	if hits != 2 && hits != 3 {
+		t.Errorf("Expected an idempotent request to be sent at most twice but it was sent %d times", hits-1)
+	}
+	os.RemoveAll("msp")
+}
+
+func TestClientRequestTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer ts.Close()
+
+	c := &Client{Config: &ClientConfig{URL: ts.URL}}
+	c.Config.Connection.RequestTimeout = 50 * time.Millisecond
+	c.Config.Connection.Retries = -1
+	start := time.Now()
+	_, err := c.GetCAInfo(&api.GetCAInfoRequest{})
+	if err == nil {
+		t.Error("Request which timed out should have failed")
+	}
+	if time.Since(start) >= time.Second {
+		t.Error("Request did not time out")
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		time.Sleep(time.Second)
+	}))
+	defer ts.Close()
+
+	// A request is abandoned when its context's deadline expires
+	c := &Client{Config: &ClientConfig{URL: ts.URL}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.GetCAInfoContext(ctx, &api.GetCAInfoRequest{})
+	if err == nil {
+		t.Error("Request whose deadline expired should have failed")
+	}
+	if time.Since(start) >= time.Second {
+		t.Error("Request did not respect the deadline of its context")
+	}
+	if hits != 1 {
+		t.Errorf("Expected a request whose deadline expired to be sent once but it was sent %d times", hits)
+	}
+
+	// A request with a canceled context is not sent
+	hits = 0
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = c.EnrollContext(ctx, &api.EnrollmentRequest{Name: "admin", Secret: "adminpw"})
+	if err == nil {
+		t.Error("Enroll with a canceled context should have failed")
+	}
+	if hits != 0 {
+		t.Errorf("Expected an enroll request with a canceled context not to be sent but it was sent %d times", hits)
+	}
+	os.RemoveAll("msp")
+}
+
+func TestClientTLSConfig(t *testing.T) {
+	var hits int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	})
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	tlsServer := httptest.NewTLSServer(handler)
+	defer tlsServer.Close()
+	caFile := "tlsserver-ca.pem"
+	err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw}), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write TLS server's certificate: %s", err)
+	}
+	defer os.Remove(caFile)
+
+	// send sends a request and returns whether it reached the server
+	send := func(c *Client) bool {
+		before := atomic.LoadInt32(&hits)
+		c.Config.Connection.Retries = -1
+		c.GetCAInfo(&api.GetCAInfoRequest{})
+		return atomic.LoadInt32(&hits) > before
+	}
+
+	// TLS files configured while TLS is not enabled are ignored for an
+	// http URL, and enable TLS for an https URL
+	c := &Client{Config: &ClientConfig{URL: ts.URL}}
+	if !send(c) {
+		t.Error("Request without TLS did not reach the server")
+	}
+	c = &Client{Config: &ClientConfig{URL: ts.URL}}
+	c.Config.TLS.CertFiles = []string{"bogus.pem"}
+	if !send(c) {
+		t.Error("Request with TLS files for an http URL did not reach the server")
+	}
+	c = &Client{Config: &ClientConfig{URL: tlsServer.URL}}
+	c.Config.TLS.CertFiles = []string{caFile}
+	if !send(c) {
+		t.Error("Request with TLS files for an https URL did not reach the server")
+	}
+
+	// TLS may only be enabled for https URLs, and then requires CA files
+	c = &Client{Config: &ClientConfig{URL: ts.URL}}
+	c.Config.TLS.Enabled = true
+	c.Config.TLS.CertFiles = []string{caFile}
+	if send(c) {
+		t.Error("Request with TLS enabled for an http URL should have failed")
+	}
+	c = &Client{Config: &ClientConfig{URL: tlsServer.URL}}
+	c.Config.TLS.Enabled = true
+	if send(c) {
+		t.Error("Request with TLS enabled but no CA certificate files should have failed")
+	}
+	c = &Client{Config: &ClientConfig{URL: tlsServer.URL}}
+	c.Config.TLS.Enabled = true
+	c.Config.TLS.CertFiles = []string{caFile}
+	if !send(c) {
+		t.Error("Request with TLS did not reach the server")
+	}
+}
+
+func TestLoadLegacyEnrollment(t *testing.T) {
+	home, err := ioutil.TempDir("", "legacyenroll")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %s", err)
+	}
+	defer os.RemoveAll(home)
+	for src, dst := range map[string]string{"ec.pem": "cert.pem", "ec-key.pem": "key.pem"} {
+		buf, err := ioutil.ReadFile(path.Join(tdDir, src))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %s", src, err)
+		}
+		err = ioutil.WriteFile(path.Join(home, dst), buf, 0600)
+		if err != nil {
+			t.Fatalf("Failed to write %s: %s", dst, err)
+		}
+	}
+	c := &Client{HomeDir: home, Config: &ClientConfig{URL: "http://localhost:7054"}}
+	if c.GetMyEnrollmentDir() != home || c.GetMyKeyFile() != path.Join(home, "key.pem") {
+		t.Errorf("Incorrect enrollment directory '%s' or key file '%s'", c.GetMyEnrollmentDir(), c.GetMyKeyFile())
+	}
+	id, err := c.LoadMyIdentity()
+	if err != nil {
+		t.Fatalf("Failed to load the enrollment in key.pem and cert.pem: %s", err)
+	}
+	if id.GetECert() == nil {
+		t.Error("The imported identity has no enrollment certificate")
+	}
+	if _, err = os.Stat(c.GetMyCertFile()); err != nil {
+		t.Errorf("The certificate was not stored in the MSP: %s", err)
+	}
+	// The identity now loads from the MSP
+	err = os.Remove(path.Join(home, "key.pem"))
+	if err != nil {
+		t.Fatalf("Failed to remove key.pem: %s", err)
+	}
+	_, err = c.LoadMyIdentity()
+	if err != nil {
+		t.Errorf("Failed to load the imported identity from the MSP: %s", err)
+	}
+}
+
+// TestDeprecatedIdentityAPI tests the functions which keep the signatures of
+// earlier releases, whose keys are in PEM form
+func TestDeprecatedIdentityAPI(t *testing.T) {
+	home, err := ioutil.TempDir("", "deprecatedid")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %s", err)
+	}
+	defer os.RemoveAll(home)
+	c := &Client{HomeDir: home, Config: &ClientConfig{URL: "http://localhost:7054"}}
+	csrPEM, keyPEM, err := c.GenCSR(nil, "deprecated")
+	if err != nil || len(csrPEM) == 0 {
+		t.Fatalf("GenCSR failed: %s", err)
+	}
+	if !strings.Contains(string(keyPEM), "PRIVATE KEY") {
+		t.Errorf("GenCSR did not return a PEM key: %s", keyPEM)
+	}
+	keyFile := path.Join(tdDir, "ec-key.pem")
+	certFile := path.Join(tdDir, "ec.pem")
+	id, err := c.LoadIdentity(keyFile, certFile)
+	if err != nil {
+		t.Fatalf("LoadIdentity failed: %s", err)
+	}
+	if id.GetECert() == nil {
+		t.Error("The loaded identity has no enrollment certificate")
+	}
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %s", keyFile, err)
+	}
+	cert, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %s", certFile, err)
+	}
+	err = c.StoreMyIdentity(key, cert)
+	if err != nil {
+		t.Fatalf("StoreMyIdentity failed: %s", err)
+	}
+	_, err = c.LoadMyIdentity()
+	if err != nil {
+		t.Errorf("Failed to load the identity stored by StoreMyIdentity: %s", err)
+	}
+}
+
+func getUnreachableURL(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return "http://" + addr
+}
+
 func getClient() *Client {
 	c, err := NewClient(clientConfig)
 	if err != nil {
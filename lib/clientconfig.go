@@ -16,10 +16,37 @@ limitations under the License.
 
 package lib
 
-import "github.com/hyperledger/fabric-ca/lib/tls"
+import (
+	"github.com/hyperledger/fabric-ca/lib/csp"
+	"github.com/hyperledger/fabric-ca/lib/tls"
+)
 
 // ClientConfig is the fabric-ca client's config
 type ClientConfig struct {
-	URL string              `mapstructure:"url"`
-	TLS tls.ClientTLSConfig `mapstructure:"tls"`
+	URL string `mapstructure:"url"`
+	// URLs are the URLs of further servers, such as the other servers of a
+	// cluster; requests are sent to each server in turn, and a request is
+	// sent to the next server when a server can not be reached
+	URLs []string `mapstructure:"urls"`
+	// Connection controls the client's connections to the servers
+	Connection ClientConnectionConfig `mapstructure:"connection"`
+	TLS        tls.ClientTLSConfig    `mapstructure:"tls"`
+	// CAName, if set, is sent in the caname header of each request to select
+	// the CA to which it is directed, overriding the caname of the request
+	CAName string `mapstructure:"caname"`
+	// MSPDir is the MSP directory in which enrollments are stored
+	// (default: msp)
+	MSPDir string `mapstructure:"mspdir"`
+	// TLSMSPDir is the MSP directory in which TLS enrollments are stored
+	// (default: tls-msp)
+	TLSMSPDir string `mapstructure:"tlsmspdir"`
+	// TLSEnrollment, if true, stores enrollments in the TLS MSP directory,
+	// whose CA chain is stored in its tlscacerts and tlsintermediatecerts
+	// directories; it is set by the client's --tls option
+	TLSEnrollment bool
+	// CSP is the config of the crypto service provider (BCCSP) which
+	// generates and stores the client's keys and signs with them
+	CSP *csp.Config `mapstructure:"csp"`
+	// Renew controls the automatic renewal of the enrollment certificate
+	Renew RenewConfig `mapstructure:"renew"`
 }
@@ -0,0 +1,333 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"path"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/cloudflare/cfssl/log"
+	"github.com/hyperledger/fabric-ca/lib/tls"
+)
+
+const (
+	// DefaultConnectTimeout is the default time allowed to connect to a
+	// server, including the TLS handshake
+	DefaultConnectTimeout = 10 * time.Second
+
+	// DefaultRequestTimeout is the default time allowed for a request,
+	// including reading the response
+	DefaultRequestTimeout = 60 * time.Second
+
+	// DefaultRequestRetries is the default number of times an idempotent
+	// request which failed transiently is retried
+	DefaultRequestRetries = 3
+
+	// DefaultRequestRetryInterval is the default time to wait before the
+	// first retry of a request; it doubles after each retry
+	DefaultRequestRetryInterval = 500 * time.Millisecond
+)
+
+// idempotentEndpoints are the endpoints whose requests may be retried
+// after a transient failure, because sending them twice has no effect.
+// Only "cainfo", which the getcacert command also uses, only reads; the
+// server has no other read-only endpoints.  The others are never retried:
+// "enroll", "reenroll" and "tcert" issue new certificates, and count against
+// the identity's maximum enrollments; "register" fails for an identity which
+// the first attempt registered; and "revoke" reports nothing revoked for
+// certificates which the first attempt revoked.
+var idempotentEndpoints = map[string]bool{
+	"cainfo": true,
+}
+
+// ClientConnectionConfig is the part of the client's config which controls
+// its connections to servers
+type ClientConnectionConfig struct {
+	// ConnectTimeout is the time allowed to connect to a server, including
+	// the TLS handshake (default: 10s)
+	ConnectTimeout time.Duration `mapstructure:"connecttimeout"`
+	// RequestTimeout is the time allowed for a request, including reading
+	// the response (default: 60s)
+	RequestTimeout time.Duration `mapstructure:"requesttimeout"`
+	// Retries is the number of times an idempotent request which failed
+	// transiently is retried; a negative value disables retries (default: 3)
+	Retries int `mapstructure:"retries"`
+	// RetryInterval is the time to wait before the first retry of a
+	// request; it doubles after each retry (default: 500ms)
+	RetryInterval time.Duration `mapstructure:"retryinterval"`
+}
+
+// getHTTPClient returns the client's HTTP client, which is created on first
+// use and then reused so that connections to the servers are kept alive
+func (c *Client) getHTTPClient() (*http.Client, error) {
+	c.httpClientMutex.Lock()
+	defer c.httpClientMutex.Unlock()
+	if c.httpClient != nil {
+		return c.httpClient, nil
+	}
+	err := c.checkTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := tls.GetClientTLSConfig(&c.Config.TLS)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &c.Config.Connection
+	connectTimeout := cfg.ConnectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = DefaultConnectTimeout
+	}
+	requestTimeout := cfg.RequestTimeout
+	if requestTimeout <= 0 {
+		requestTimeout = DefaultRequestTimeout
+	}
+	dialer := &net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	tr := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		Dial:                dialer.Dial,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: connectTimeout,
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	c.httpClient = &http.Client{Transport: tr, Timeout: requestTimeout}
+	return c.httpClient, nil
+}
+
+// checkTLSConfig checks the TLS config against the URLs of the servers.
+// Whether TLS is used depends on the scheme of each URL: if TLS is enabled,
+// the servers must have https URLs, which are verified with the configured
+// TLS settings; otherwise, servers with https URLs are verified with the
+// system's root certificates.  TLS files configured while TLS is not enabled
+// enable TLS if all of the servers have https URLs, and are ignored otherwise.
+func (c *Client) checkTLSConfig() error {
+	cfg := &c.Config.TLS
+	var insecure []string
+	for _, addr := range c.getServerURLs() {
+		u, err := NormalizeURL(addr)
+		if err != nil {
+			return fmt.Errorf("Invalid server URL '%s': %s", addr, err)
+		}
+		if u.Scheme != "https" {
+			insecure = append(insecure, addr)
+		}
+	}
+	cfg.EnableIfConfigured(len(insecure) == 0, "fabric-ca server")
+	if cfg.Enabled && len(insecure) > 0 {
+		return fmt.Errorf("TLS is enabled, but the server URL '%s' is not an https URL", insecure[0])
+	}
+	return nil
+}
+
+// getServerURLs returns the URLs of the servers to which requests are sent,
+// which are the configured URL followed by the other configured URLs
+func (c *Client) getServerURLs() []string {
+	var urls []string
+	seen := map[string]bool{}
+	for _, u := range append([]string{c.Config.URL}, c.Config.URLs...) {
+		if u == "" || seen[u] {
+			continue
+		}
+		seen[u] = true
+		urls = append(urls, u)
+	}
+	return urls
+}
+
+// getRequestURLs returns the URLs to which a request may be sent, in the
+// order in which they are tried.  With more than one server, successive
+// requests start at successive servers.
+func (c *Client) getRequestURLs(req *http.Request) ([]string, error) {
+	servers := c.getServerURLs()
+	if len(servers) <= 1 {
+		return []string{req.URL.String()}, nil
+	}
+	endpoint := path.Base(req.URL.Path)
+	start := int(atomic.AddUint32(&c.nextServer, 1)-1) % len(servers)
+	urls := make([]string, len(servers))
+	for i := range servers {
+		u, err := getServerURL(servers[(start+i)%len(servers)], endpoint)
+		if err != nil {
+			return nil, err
+		}
+		urls[i] = u
+	}
+	return urls, nil
+}
+
+// sendRequest sends a request to each of the URLs in turn until one of the
+// servers is reached.  Idempotent requests which fail transiently are
+// retried with exponential backoff; other requests are never sent twice.
+// Neither failover nor retries continue once the request's context is done.
+func (c *Client) sendRequest(req *http.Request, reqStr string) (*http.Response, error) {
+	httpClient, err := c.getHTTPClient()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get client TLS config [%s]; not sending\n%s", err, reqStr)
+	}
+	urls, err := c.getRequestURLs(req)
+	if err != nil {
+		return nil, err
+	}
+	var body []byte
+	if req.Body != nil {
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read body of request:\n%s", reqStr)
+		}
+	}
+	cfg := &c.Config.Connection
+	retries := cfg.Retries
+	if retries == 0 {
+		retries = DefaultRequestRetries
+	}
+	if !idempotentEndpoints[path.Base(req.URL.Path)] {
+		retries = 0
+	}
+	backoff := cfg.RetryInterval
+	if backoff <= 0 {
+		backoff = DefaultRequestRetryInterval
+	}
+	ctx := req.Context()
+	next := 0
+	for attempt := 0; ; attempt++ {
+		var resp *http.Response
+		// Fail over to the next server when a server can not be reached,
+		// which means that the request was not received
+		for failovers := 0; ; failovers++ {
+			resp, err = c.doRequest(httpClient, req, urls[next%len(urls)], body)
+			next++
+			if err == nil || !isDialError(err) || failovers >= len(urls)-1 || ctx.Err() != nil {
+				break
+			}
+			log.Warningf("Failed to connect to server; failing over to the next server: %s", err)
+		}
+		transient := err != nil || isTransientStatus(resp.StatusCode)
+		if !transient || attempt >= retries || ctx.Err() != nil {
+			if err != nil {
+				return nil, fmt.Errorf("POST failure [%s]; not sending\n%s", err, reqStr)
+			}
+			return resp, nil
+		}
+		if err == nil {
+			log.Warningf("Server returned status code %d; retrying in %s", resp.StatusCode, backoff)
+			resp.Body.Close()
+		} else {
+			log.Warningf("Request failed; retrying in %s: %s", backoff, err)
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("POST failure [%s]; not sending\n%s", ctx.Err(), reqStr)
+		}
+		backoff *= 2
+	}
+}
+
+// Send a copy of the request, with its body and context, to the URL.  A
+// request which fails on a reused connection because the server closed it,
+// such as after the server restarted, is sent once more on a new connection
+// if the connection was closed before the request was written, or if the
+// request is idempotent.  Other requests may already have been handled by
+// the server, so they are never sent twice.
+func (c *Client) doRequest(httpClient *http.Client, req *http.Request, u string, body []byte) (*http.Response, error) {
+	send := func() (*http.Response, bool, error) {
+		r, err := http.NewRequestWithContext(req.Context(), req.Method, u, bytes.NewReader(body))
+		if err != nil {
+			return nil, false, err
+		}
+		for name, values := range req.Header {
+			r.Header[name] = values
+		}
+		reused := false
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused },
+		}
+		r = r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+		resp, err := httpClient.Do(r)
+		return resp, reused, err
+	}
+	resp, reused, err := send()
+	resend := isClosedIdleConnError
+	if idempotentEndpoints[path.Base(req.URL.Path)] {
+		resend = isClosedConnError
+	}
+	if err != nil && reused && resend(err) && req.Context().Err() == nil {
+		log.Debugf("Connection to %s was closed by the server; resending on a new connection: %s", u, err)
+		httpClient.CloseIdleConnections()
+		resp, _, err = send()
+	}
+	return resp, err
+}
+
+// isClosedConnError returns true if the error is that of a request on a
+// connection which the server closed before responding
+func isClosedConnError(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	return isClosedIdleConnError(err)
+}
+
+// isClosedIdleConnError returns true if the error is that of a request on a
+// connection which the server closed while it was idle, so that none of the
+// request was written.  The error of net/http for it is not exported.
+func isClosedIdleConnError(err error) bool {
+	return strings.Contains(err.Error(), "server closed idle connection")
+}
+
+// isDialError returns true if the error is a failure to connect to a server
+func isDialError(err error) bool {
+	for {
+		switch e := err.(type) {
+		case *net.OpError:
+			return e.Op == "dial"
+		case *url.Error:
+			err = e.Err
+		default:
+			return false
+		}
+	}
+}
+
+// isTransientStatus returns true if the HTTP status code indicates that the
+// server was temporarily unable to handle a request
+func isTransientStatus(code int) bool {
+	switch code {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
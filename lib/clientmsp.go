@@ -0,0 +1,290 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudflare/cfssl/log"
+	"github.com/hyperledger/fabric-ca/lib/csp"
+	"github.com/hyperledger/fabric-ca/util"
+)
+
+// The directories of an MSP (Membership Service Provider) in which the
+// client stores its enrollments
+const (
+	mspSignCertsDir            = "signcerts"
+	mspKeystoreDir             = "keystore"
+	mspCACertsDir              = "cacerts"
+	mspIntermediateCertsDir    = "intermediatecerts"
+	mspAdminCertsDir           = "admincerts"
+	mspCRLsDir                 = "crls"
+	mspTLSCACertsDir           = "tlscacerts"
+	mspTLSIntermediateCertsDir = "tlsintermediatecerts"
+)
+
+// GetMSPDir returns the path to the MSP directory in which the client's
+// enrollment is stored, which is the TLS MSP directory for TLS enrollments
+func (c *Client) GetMSPDir() string {
+	dir := c.Config.MSPDir
+	if c.Config.TLSEnrollment {
+		dir = c.Config.TLSMSPDir
+		if dir == "" {
+			dir = "tls-msp"
+		}
+	} else if dir == "" {
+		dir = "msp"
+	}
+	if !filepath.IsAbs(dir) {
+		dir = path.Join(c.HomeDir, dir)
+	}
+	return dir
+}
+
+// GetCACertsDir returns the path to the directory of the MSP's root CA
+// certificates, which is tlscacerts for TLS enrollments
+func (c *Client) GetCACertsDir() string {
+	if c.Config.TLSEnrollment {
+		return path.Join(c.GetMSPDir(), mspTLSCACertsDir)
+	}
+	return path.Join(c.GetMSPDir(), mspCACertsDir)
+}
+
+// GetIntermediateCertsDir returns the path to the directory of the MSP's
+// intermediate CA certificates, which is tlsintermediatecerts for TLS
+// enrollments
+func (c *Client) GetIntermediateCertsDir() string {
+	if c.Config.TLSEnrollment {
+		return path.Join(c.GetMSPDir(), mspTLSIntermediateCertsDir)
+	}
+	return path.Join(c.GetMSPDir(), mspIntermediateCertsDir)
+}
+
+// GetMyCertFile returns the path to this identity's certificate file
+func (c *Client) GetMyCertFile() string {
+	return path.Join(c.GetMSPDir(), mspSignCertsDir, "cert.pem")
+}
+
+// LoadMyIdentity loads the client's identity from its MSP directory.  The
+// identity's key is held by the client's BCCSP.  An enrollment stored by
+// earlier releases in key.pem and cert.pem files is imported into the MSP
+// the first time it is loaded.
+func (c *Client) LoadMyIdentity() (*Identity, error) {
+	if !c.Config.TLSEnrollment && !util.FileExists(c.GetMyCertFile()) {
+		err := c.importLegacyEnrollment()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.LoadIdentityFromCert(c.GetMyCertFile())
+}
+
+// StoreMyIdentity stores my identity to disk
+//
+// Deprecated: the PEM key is imported into the client's BCCSP rather than
+// written to GetMyKeyFile, and the certificate is stored in the client's MSP
+// directory; use StoreMyCert, with a key held by the BCCSP, instead.
+func (c *Client) StoreMyIdentity(key, cert []byte) error {
+	bccspInst, err := c.GetCSP()
+	if err != nil {
+		return err
+	}
+	_, err = csp.ImportPEMKey(bccspInst, key)
+	if err != nil {
+		return err
+	}
+	return c.StoreMyCert(cert)
+}
+
+// StoreMyCert stores my identity's certificate in the client's MSP
+// directory, creating the directories of the MSP.  The key is stored by the
+// client's BCCSP when it is generated; by default, the BCCSP stores it in the
+// MSP's keystore directory in a file named by its SKI (Subject Key Identifier).
+func (c *Client) StoreMyCert(cert []byte) error {
+	err := c.makeMSPDirs()
+	if err != nil {
+		return err
+	}
+	err = util.WriteFileAtomically(c.GetMyCertFile(), cert, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to store certificate: %s", err)
+	}
+	log.Debugf("Stored certificate in %s", c.GetMyCertFile())
+	return nil
+}
+
+// GetMyKeyFile returns the path to the key file in which earlier releases
+// stored this identity's key
+//
+// Deprecated: the key is held by the client's BCCSP; the file is only read
+// to import an enrollment stored by earlier releases.
+func (c *Client) GetMyKeyFile() string {
+	file := os.Getenv("FABRIC_CA_KEY_FILE")
+	if file == "" {
+		file = path.Join(c.GetMyEnrollmentDir(), "key.pem")
+	}
+	return file
+}
+
+// GetMyEnrollmentDir returns the path to the directory in which earlier
+// releases stored this identity's enrollment
+//
+// Deprecated: enrollments are stored in the client's MSP directory; see
+// GetMSPDir.
+func (c *Client) GetMyEnrollmentDir() string {
+	dir := os.Getenv("FABRIC_CA_ENROLLMENT_DIR")
+	if dir == "" {
+		dir = c.HomeDir
+	}
+	return dir
+}
+
+// Import the enrollment which earlier releases stored in the key.pem and
+// cert.pem files of the enrollment directory (or the files named by the
+// FABRIC_CA_KEY_FILE and FABRIC_CA_CERT_FILE environment variables) into the
+// client's MSP.  The key is imported into the client's BCCSP and the old
+// files are left in place.
+func (c *Client) importLegacyEnrollment() error {
+	certFile := os.Getenv("FABRIC_CA_CERT_FILE")
+	if certFile == "" {
+		certFile = path.Join(c.GetMyEnrollmentDir(), "cert.pem")
+	}
+	keyFile := c.GetMyKeyFile()
+	if !util.FileExists(certFile) || !util.FileExists(keyFile) {
+		return nil
+	}
+	log.Infof("Importing the enrollment in %s and %s into the MSP at %s", certFile, keyFile, c.GetMSPDir())
+	cert, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return fmt.Errorf("Failed to read certificate %s: %s", certFile, err)
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return fmt.Errorf("Failed to read key %s: %s", keyFile, err)
+	}
+	err = c.makeMSPDirs()
+	if err != nil {
+		return err
+	}
+	bccspInst, err := c.GetCSP()
+	if err != nil {
+		return err
+	}
+	_, err = csp.ImportPEMKey(bccspInst, keyPEM)
+	if err != nil {
+		return fmt.Errorf("Failed to import key %s: %s", keyFile, err)
+	}
+	_, err = c.getKey(cert)
+	if err != nil {
+		return fmt.Errorf("The key in %s does not match the certificate in %s: %s", keyFile, certFile, err)
+	}
+	err = c.StoreMyCert(cert)
+	if err != nil {
+		return err
+	}
+	log.Infof("Imported the enrollment in %s; the plaintext key in %s is no longer used and may be removed", certFile, keyFile)
+	return nil
+}
+
+// StoreCAChain stores the root CA certificates of a CA chain in the MSP's
+// CA certificates directory and the intermediate CA certificates in its
+// intermediate certificates directory.  The file in each directory is named
+// after the server and CA to which the client sends requests.
+func (c *Client) StoreCAChain(chain []byte) error {
+	err := c.makeMSPDirs()
+	if err != nil {
+		return err
+	}
+	var roots, intermediates []byte
+	for block, rest := pem.Decode(chain); block != nil; block, rest = pem.Decode(rest) {
+		cert, err := BytesToX509Cert(pem.EncodeToMemory(block))
+		if err != nil {
+			return fmt.Errorf("Invalid CA chain: %s", err)
+		}
+		if bytes.Equal(cert.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(cert) == nil {
+			roots = append(roots, pem.EncodeToMemory(block)...)
+		} else {
+			intermediates = append(intermediates, pem.EncodeToMemory(block)...)
+		}
+	}
+	if roots == nil {
+		return fmt.Errorf("The CA chain does not contain a root CA certificate")
+	}
+	name, err := c.getCAChainFileName()
+	if err != nil {
+		return err
+	}
+	file := path.Join(c.GetCACertsDir(), name)
+	err = util.WriteFileAtomically(file, roots, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to store root CA certificates: %s", err)
+	}
+	log.Debugf("Stored root CA certificates in %s", file)
+	if intermediates != nil {
+		file = path.Join(c.GetIntermediateCertsDir(), name)
+		err = util.WriteFileAtomically(file, intermediates, 0644)
+		if err != nil {
+			return fmt.Errorf("Failed to store intermediate CA certificates: %s", err)
+		}
+		log.Debugf("Stored intermediate CA certificates in %s", file)
+	}
+	return nil
+}
+
+// Create the directories of the client's MSP
+func (c *Client) makeMSPDirs() error {
+	mspDir := c.GetMSPDir()
+	dirs := []string{
+		mspSignCertsDir,
+		mspKeystoreDir,
+		mspCACertsDir,
+		mspIntermediateCertsDir,
+		mspAdminCertsDir,
+		mspCRLsDir,
+		mspTLSCACertsDir,
+		mspTLSIntermediateCertsDir,
+	}
+	for _, dir := range dirs {
+		dir = path.Join(mspDir, dir)
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return fmt.Errorf("Failed to create directory %s: %s", dir, err)
+		}
+	}
+	return nil
+}
+
+// Return the name of the file in which a CA chain is stored, which
+// identifies the server by its host and port, and the CA by its name if set
+func (c *Client) getCAChainFileName() (string, error) {
+	u, err := NormalizeURL(c.Config.URL)
+	if err != nil {
+		return "", err
+	}
+	name := strings.Replace(u.Host, ":", "-", -1)
+	if c.Config.CAName != "" {
+		name = name + "-" + c.Config.CAName
+	}
+	return name + ".pem", nil
+}
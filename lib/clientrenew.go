@@ -0,0 +1,233 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/cloudflare/cfssl/log"
+	"github.com/hyperledger/fabric-ca/api"
+	"github.com/hyperledger/fabric-ca/lib/csp"
+	"github.com/hyperledger/fabric-ca/util"
+)
+
+const (
+	// DefaultRenewFraction is the default fraction of the lifetime of the
+	// enrollment certificate after which it is renewed
+	DefaultRenewFraction = 0.8
+
+	// DefaultRenewRetryInterval is the default time to wait before the first
+	// retry of a failed renewal
+	DefaultRenewRetryInterval = 30 * time.Second
+
+	// DefaultRenewMaxRetryInterval is the default maximum time to wait
+	// between retries of a failed renewal
+	DefaultRenewMaxRetryInterval = time.Hour
+
+	// renewedCertEnvVar is the environment variable in which the renewal
+	// hook is passed the name of the renewed certificate file
+	renewedCertEnvVar = "FABRIC_CA_CLIENT_RENEWED_CERTFILE"
+)
+
+// RenewConfig is the part of the client's config which controls the
+// automatic renewal of its enrollment certificate
+type RenewConfig struct {
+	// Fraction is the fraction of the certificate's lifetime after which it
+	// is renewed, between 0 and 1 (default: 0.8)
+	Fraction float64 `mapstructure:"fraction"`
+	// Hook, if set, is a shell command which is run after the certificate
+	// is renewed, such as one which restarts the process which uses it
+	Hook string `mapstructure:"hook"`
+	// RetryInterval is the time to wait before the first retry of a failed
+	// renewal; it doubles after each failure (default: 30s)
+	RetryInterval time.Duration `mapstructure:"retryinterval"`
+	// MaxRetryInterval is the maximum time to wait between retries
+	// (default: 1h)
+	MaxRetryInterval time.Duration `mapstructure:"maxretryinterval"`
+}
+
+// GetRenewalTime returns the time at which the client's enrollment
+// certificate is due to be renewed, and the time at which it expires
+func (c *Client) GetRenewalTime() (renewAt, notAfter time.Time, err error) {
+	fraction := c.Config.Renew.Fraction
+	if fraction == 0 {
+		fraction = DefaultRenewFraction
+	}
+	if fraction < 0 || fraction > 1 {
+		return renewAt, notAfter, fmt.Errorf("The renewal fraction must be between 0 and 1 but is %v", fraction)
+	}
+	var cert *x509.Certificate
+	certFile := c.GetMyCertFile()
+	buf, err := ioutil.ReadFile(certFile)
+	if err == nil {
+		cert, err = util.GetX509CertificateFromPEM(buf)
+	}
+	if err != nil {
+		return renewAt, notAfter, fmt.Errorf("Failed to load enrollment certificate %s: %s", certFile, err)
+	}
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	renewAt = cert.NotBefore.Add(time.Duration(float64(lifetime) * fraction))
+	return renewAt, cert.NotAfter, nil
+}
+
+// Renew reenrolls the client's identity with a new key, replaces the
+// certificate files in its MSP directory with the new ones, deletes the old
+// key, and runs the renewal hook if one is configured
+func (c *Client) Renew(req *api.ReenrollmentRequest) error {
+	err := c.renew(context.Background(), req)
+	if err != nil {
+		return err
+	}
+	return c.runRenewHook()
+}
+
+// Reenroll the client's identity, store the new certificate and delete the
+// old key
+func (c *Client) renew(ctx context.Context, req *api.ReenrollmentRequest) error {
+	id, err := c.LoadMyIdentity()
+	if err != nil {
+		return err
+	}
+	newID, err := id.ReenrollContext(ctx, req)
+	if err != nil {
+		return fmt.Errorf("Failed to reenroll: %s", err)
+	}
+	err = newID.Store()
+	if err != nil {
+		return err
+	}
+	log.Infof("Renewed enrollment certificate %s", c.GetMyCertFile())
+	// The old key is no longer used, so that the key store does not grow
+	// with each renewal.  The certificate is already renewed, so a failure
+	// to delete the key does not fail the renewal.
+	oldSKI := id.GetECert().key.SKI()
+	if !bytes.Equal(oldSKI, newID.GetECert().key.SKI()) {
+		err = csp.DeleteKey(c.cspKeyStore, oldSKI)
+		if err != nil {
+			log.Warningf("Failed to delete the key of the old enrollment certificate: %s", err)
+		}
+	}
+	return nil
+}
+
+// RunRenewer renews the client's enrollment certificate each time it is due
+// until stop is closed, which also cancels a renewal in progress.  A failed
+// renewal is retried with exponential backoff; RunRenewer returns an error if
+// the certificate expires before it is renewed, because an expired
+// certificate can not be used to reenroll.
+func (c *Client) RunRenewer(req *api.ReenrollmentRequest, stop <-chan struct{}) error {
+	retryInterval := c.Config.Renew.RetryInterval
+	if retryInterval <= 0 {
+		retryInterval = DefaultRenewRetryInterval
+	}
+	maxRetryInterval := c.Config.Renew.MaxRetryInterval
+	if maxRetryInterval <= 0 {
+		maxRetryInterval = DefaultRenewMaxRetryInterval
+	}
+	// Cancel the request of a renewal in progress when stop is closed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	backoff := retryInterval
+	renewed := false
+	for {
+		renewAt, notAfter, err := c.GetRenewalTime()
+		if err != nil {
+			return err
+		}
+		wait := renewAt.Sub(time.Now())
+		if wait <= 0 && renewed {
+			// The new certificate is already due, such as when its lifetime is
+			// limited by the CA certificate's, so wait rather than renewing it
+			// continuously
+			log.Warningf("The renewed enrollment certificate is already due for renewal")
+			wait = retryInterval
+			renewAt = time.Now().Add(wait)
+		}
+		renewed = false
+		if wait > 0 {
+			log.Infof("Enrollment certificate will be renewed at %s", renewAt)
+			if !sleepUntilStopped(wait, stop) {
+				return nil
+			}
+		}
+		if time.Now().After(notAfter) {
+			return fmt.Errorf("The enrollment certificate expired at %s before it was renewed", notAfter)
+		}
+		err = c.renew(ctx, req)
+		if err == nil {
+			// The certificate was renewed, so a failed hook is not retried
+			err = c.runRenewHook()
+			if err != nil {
+				log.Errorf("%s", err)
+			}
+			backoff = retryInterval
+			renewed = true
+			continue
+		}
+		log.Errorf("Failed to renew enrollment certificate; retrying in %s: %s", backoff, err)
+		if !sleepUntilStopped(backoff, stop) {
+			return nil
+		}
+		backoff *= 2
+		if backoff > maxRetryInterval {
+			backoff = maxRetryInterval
+		}
+	}
+}
+
+// Run the renewal hook, passing it the name of the renewed certificate file
+func (c *Client) runRenewHook() error {
+	hook := c.Config.Renew.Hook
+	if hook == "" {
+		return nil
+	}
+	log.Debugf("Running renewal hook: %s", hook)
+	cmd := exec.Command("sh", "-c", hook)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", renewedCertEnvVar, c.GetMyCertFile()))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("The renewal hook failed: %s: %s", err, out)
+	}
+	log.Debugf("Renewal hook output: %s", out)
+	return nil
+}
+
+// Wait for the duration, returning false if stop is closed first
+func sleepUntilStopped(d time.Duration, stop <-chan struct{}) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
@@ -19,11 +19,11 @@ package csp
 import (
 	"crypto"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"os"
-	"path"
 
+	"github.com/cloudflare/cfssl/csr"
 	"github.com/hyperledger/fabric/bccsp"
 	"github.com/hyperledger/fabric/bccsp/factory"
 	"github.com/hyperledger/fabric/bccsp/signer"
@@ -65,17 +65,26 @@ type SWConfig struct {
 	HashFamily    string `json:"hash_family,omitempty"`
 	SecurityLevel int    `json:"security_level,omitempty"`
 	Ephemeral     bool   `json:"ephemeral,omitempty"`
+	// Password encrypts the keys in the key store; if neither it nor
+	// PasswordFile is set, the keys are not encrypted
+	Password string `json:"password,omitempty"`
+	// PasswordFile is the file containing the password
+	PasswordFile string `json:"password_file,omitempty"`
 }
 
 // Get returns the instance of the software CSP
 func (sc *SWConfig) Get() (bccsp.BCCSP, error) {
 	// Set defaults
-	keyStoreDir := getStrVal(sc.KeyStoreDir, path.Join(os.Getenv("HOME"), ".bccsp", "ks"))
+	keyStoreDir := sc.getKeyStoreDir()
 	hashFamily := getStrVal(sc.HashFamily, "SHA2")
 	secLevel := getIntVal(sc.SecurityLevel, 256)
+	pwd, err := sc.GetPassword()
+	if err != nil {
+		return nil, err
+	}
 	// Init keystore
 	ks := &sw.FileBasedKeyStore{}
-	err := ks.Init(nil, keyStoreDir, false)
+	err = ks.Init(pwd, keyStoreDir, false)
 	if err != nil {
 		return nil, fmt.Errorf("Failed initializing software key store: %s", err)
 	}
@@ -89,6 +98,19 @@ func GetSignerFromSKIFile(skiFile string, csp bccsp.BCCSP) (crypto.Signer, error
 	if csp == nil {
 		return nil, fmt.Errorf("csp is nil")
 	}
+	privateKey, err := GetKeyFromSKIFile(skiFile, csp)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := GetSigner(csp, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to initialize signer from SKI file [%s]: %s", skiFile, err)
+	}
+	return signer, nil
+}
+
+// GetKeyFromSKIFile returns the key referenced by an SKI file
+func GetKeyFromSKIFile(skiFile string, csp bccsp.BCCSP) (bccsp.Key, error) {
 	keyBuff, err := ioutil.ReadFile(skiFile)
 	if err != nil {
 		return nil, fmt.Errorf("Could not read SKI file [%s]: %s", skiFile, err)
@@ -107,15 +129,84 @@ func GetSignerFromSKIFile(skiFile string, csp bccsp.BCCSP) (crypto.Signer, error
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get key from SKI file [%s]: %s", skiFile, err)
 	}
+	return privateKey, nil
+}
+
+// IsSKIFile returns true if the PEM in buf references a key by its SKI
+func IsSKIFile(buf []byte) bool {
+	block, _ := pem.Decode(buf)
+	return block != nil && block.Type == SKIPEM
+}
 
+// SKIToPEM returns the PEM encoding of a key's SKI, which is the content of
+// an SKI file referencing the key
+func SKIToPEM(key bccsp.Key) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: SKIPEM, Bytes: key.SKI()})
+}
+
+// GetSigner returns a crypto.Signer for a private key held by the BCCSP
+func GetSigner(csp bccsp.BCCSP, key bccsp.Key) (crypto.Signer, error) {
 	signer := &signer.CryptoSigner{}
-	if err = signer.Init(csp, privateKey); err != nil {
-		return nil, fmt.Errorf("Failed to initialize signer from SKI file [%s]: %s", skiFile, err)
+	err := signer.Init(csp, key)
+	if err != nil {
+		return nil, err
 	}
-
 	return signer, nil
 }
 
+// GenKey generates a key in the BCCSP for the key request, which is an
+// ECDSA P-256 key by default, and returns the key and a signer for it
+func GenKey(csp bccsp.BCCSP, req csr.KeyRequest) (bccsp.Key, crypto.Signer, error) {
+	if req == nil {
+		req = csr.NewBasicKeyRequest()
+	}
+	var opts bccsp.KeyGenOpts
+	switch req.Algo() {
+	case "ecdsa":
+		switch req.Size() {
+		case 256:
+			opts = &bccsp.ECDSAP256KeyGenOpts{}
+		case 384:
+			opts = &bccsp.ECDSAP384KeyGenOpts{}
+		}
+	case "rsa":
+		switch req.Size() {
+		case 2048:
+			opts = &bccsp.RSA2048KeyGenOpts{}
+		case 3072:
+			opts = &bccsp.RSA3072KeyGenOpts{}
+		case 4096:
+			opts = &bccsp.RSA4096KeyGenOpts{}
+		}
+	}
+	if opts == nil {
+		return nil, nil, fmt.Errorf("Unsupported key request: %s-%d", req.Algo(), req.Size())
+	}
+	key, err := csp.KeyGen(opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to generate %s-%d key: %s", req.Algo(), req.Size(), err)
+	}
+	signer, err := GetSigner(csp, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to initialize signer: %s", err)
+	}
+	return key, signer, nil
+}
+
+// ImportPEMKey imports a PEM-encoded private key into the BCCSP's key store.
+// Only ECDSA keys may be imported.
+func ImportPEMKey(csp bccsp.BCCSP, keyPEM []byte) (bccsp.Key, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("Failed decoding PEM key")
+	}
+	key, err := csp.KeyImport(block.Bytes, &bccsp.ECDSAPrivateKeyImportOpts{Temporary: false})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to import key: %s", err)
+	}
+	return key, nil
+}
+
 // GenRootKey generates a new root key
 func GenRootKey(csp bccsp.BCCSP) (bccsp.Key, error) {
 	opts := &bccsp.AES256KeyGenOpts{Temporary: true}
@@ -17,6 +17,10 @@ limitations under the License.
 package csp_test
 
 import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
 	"path"
 	"testing"
 
@@ -46,6 +50,81 @@ func TestBCCSP(t *testing.T) {
 	getSignerFromSKIFile("ec-key.ski", nil, "nil bccsp", t)
 }
 
+// TestKeyStorePassword tests an encrypted software key store and its rekey
+func TestKeyStorePassword(t *testing.T) {
+	// Non-ephemeral software CSPs are shared, so each config here is ephemeral
+	ks := getTestFile("encks")
+	os.RemoveAll(ks)
+	defer os.RemoveAll(ks)
+	sw := &csp.SWConfig{KeyStoreDir: ks, Password: "oldpw", Ephemeral: true}
+	key := genKey(&csp.Config{SW: sw}, t)
+	ski := key.SKI()
+	files, err := ioutil.ReadDir(ks)
+	if err != nil {
+		t.Fatalf("Failed to read key store: %s", err)
+	}
+	for _, file := range files {
+		buf, err := ioutil.ReadFile(path.Join(ks, file.Name()))
+		if err != nil {
+			t.Fatalf("Failed to read key file: %s", err)
+		}
+		block, _ := pem.Decode(buf)
+		if block == nil || !x509.IsEncryptedPEMBlock(block) {
+			t.Errorf("Key file %s is not encrypted", file.Name())
+		}
+	}
+	getKey(&csp.Config{SW: &csp.SWConfig{KeyStoreDir: ks, Ephemeral: true}}, ski, "no password", t)
+	getKey(&csp.Config{SW: sw}, ski, "", t)
+
+	err = sw.Rekey(nil)
+	if err == nil {
+		t.Error("Rekey with an empty password should have failed but didn't")
+	}
+	err = (&csp.SWConfig{KeyStoreDir: ks, Password: "wrongpw"}).Rekey([]byte("newpw"))
+	if err == nil {
+		t.Error("Rekey with the wrong password should have failed but didn't")
+	}
+	err = sw.Rekey([]byte("newpw"))
+	if err != nil {
+		t.Fatalf("Failed to rekey key store: %s", err)
+	}
+	getKey(&csp.Config{SW: sw}, ski, "old password", t)
+	pwFile := getTestFile("encks.pw")
+	err = ioutil.WriteFile(pwFile, []byte("newpw\n"), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write password file: %s", err)
+	}
+	defer os.Remove(pwFile)
+	getKey(&csp.Config{SW: &csp.SWConfig{KeyStoreDir: ks, PasswordFile: pwFile, Ephemeral: true}},
+		ski, "", t)
+}
+
+func genKey(cfg *csp.Config, t *testing.T) bccsp.Key {
+	sw, err := csp.Get(cfg)
+	if err != nil {
+		t.Fatalf("Failed to get BCCSP instance: %s", err)
+	}
+	key, err := sw.KeyGen(&bccsp.ECDSAP256KeyGenOpts{Temporary: false})
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+	return key
+}
+
+func getKey(cfg *csp.Config, ski []byte, expectFailure string, t *testing.T) {
+	sw, err := csp.Get(cfg)
+	if err == nil {
+		_, err = sw.GetKey(ski)
+	}
+	if err != nil {
+		if expectFailure == "" {
+			t.Errorf("Failed to get key: %s", err)
+		}
+	} else if expectFailure != "" {
+		t.Errorf("Expected failure but passed: %s", expectFailure)
+	}
+}
+
 func getSignerFromSKIFile(name string, bccsp bccsp.BCCSP, expectFailure string, t *testing.T) {
 	file := getTestFile(name)
 	_, err := csp.GetSignerFromSKIFile(file, bccsp)
@@ -0,0 +1,194 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csp
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/cloudflare/cfssl/log"
+)
+
+// The suffixes of the names of the private, public and secret key files
+// in the software CSP's key store
+var keyFileSuffixes = []string{"_sk", "_pk", "_key"}
+
+// writeFile writes the files of the re-encrypted key store
+var writeFile = ioutil.WriteFile
+
+// GetPassword returns the password of the key store, which is nil if the
+// keys are not encrypted
+func (sc *SWConfig) GetPassword() ([]byte, error) {
+	if sc.Password != "" {
+		return []byte(sc.Password), nil
+	}
+	if sc.PasswordFile == "" {
+		return nil, nil
+	}
+	buf, err := ioutil.ReadFile(sc.PasswordFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read key store password file: %s", err)
+	}
+	pwd := strings.TrimRight(string(buf), "\r\n")
+	if pwd == "" {
+		return nil, fmt.Errorf("The key store password file '%s' is empty", sc.PasswordFile)
+	}
+	return []byte(pwd), nil
+}
+
+// Rekey re-encrypts the keys in the key store, which are encrypted with the
+// configured password, with a new password.  The re-encrypted key store is
+// written to a staging directory next to the key store, which then replaces
+// it, so that the key store is never left with keys encrypted with different
+// passwords.
+func (sc *SWConfig) Rekey(newPassword []byte) error {
+	if len(newPassword) == 0 {
+		return errors.New("The new key store password must not be empty")
+	}
+	pwd, err := sc.GetPassword()
+	if err != nil {
+		return err
+	}
+	dir := sc.getKeyStoreDir()
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("Failed to read key store directory: %s", err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("Failed to read key store directory: %s", err)
+	}
+	contents := map[string][]byte{}
+	for _, file := range files {
+		name := path.Join(dir, file.Name())
+		if !file.Mode().IsRegular() {
+			return fmt.Errorf("The key store contains '%s', which is not a file", name)
+		}
+		buf, err := ioutil.ReadFile(name)
+		if err != nil {
+			return fmt.Errorf("Failed to read key file: %s", err)
+		}
+		if isKeyFile(file.Name()) {
+			buf, err = rekeyPEM(buf, pwd, newPassword)
+			if err != nil {
+				return fmt.Errorf("Failed to rekey key file '%s': %s", name, err)
+			}
+		}
+		contents[file.Name()] = buf
+	}
+	staging, err := ioutil.TempDir(path.Dir(dir), path.Base(dir)+".rekey")
+	if err != nil {
+		return fmt.Errorf("Failed to create staging directory: %s", err)
+	}
+	defer os.RemoveAll(staging)
+	for _, file := range files {
+		err = writeFile(path.Join(staging, file.Name()), contents[file.Name()], file.Mode().Perm())
+		if err != nil {
+			return fmt.Errorf("Failed to write key file: %s", err)
+		}
+	}
+	err = os.Chmod(staging, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("Failed to set mode of staging directory: %s", err)
+	}
+	return swapDir(staging, dir)
+}
+
+// DeleteKey removes the files of the key whose SKI is 'ski' from the key
+// store directory 'dir'.  The BCCSP can not delete keys, so the files, which
+// are named by the SKI, are removed.
+func DeleteKey(dir string, ski []byte) error {
+	prefix := hex.EncodeToString(ski)
+	for _, suffix := range keyFileSuffixes {
+		err := os.Remove(path.Join(dir, prefix+suffix))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Failed to delete key file: %s", err)
+		}
+	}
+	return nil
+}
+
+// swapDir replaces the directory 'dir' with the directory 'newDir'.  The
+// old directory is moved aside first, and moved back if 'newDir' cannot
+// take its place.
+func swapDir(newDir, dir string) error {
+	oldDir := newDir + ".old"
+	err := os.Rename(dir, oldDir)
+	if err != nil {
+		return fmt.Errorf("Failed to move key store '%s' aside: %s", dir, err)
+	}
+	err = os.Rename(newDir, dir)
+	if err != nil {
+		rerr := os.Rename(oldDir, dir)
+		if rerr != nil {
+			return fmt.Errorf("Failed to replace key store '%s': %s; the key store is in '%s' and could not be moved back: %s",
+				dir, err, oldDir, rerr)
+		}
+		return fmt.Errorf("Failed to replace key store '%s': %s", dir, err)
+	}
+	err = os.RemoveAll(oldDir)
+	if err != nil {
+		log.Warningf("Failed to remove the previous key store '%s': %s", oldDir, err)
+	}
+	return nil
+}
+
+func (sc *SWConfig) getKeyStoreDir() string {
+	return getStrVal(sc.KeyStoreDir, path.Join(os.Getenv("HOME"), ".bccsp", "ks"))
+}
+
+// rekeyPEM decrypts a PEM block with pwd, if it is encrypted, and encrypts
+// it with newPwd
+func rekeyPEM(buf, pwd, newPwd []byte) ([]byte, error) {
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		return nil, errors.New("Failed decoding PEM")
+	}
+	der := block.Bytes
+	if x509.IsEncryptedPEMBlock(block) {
+		if len(pwd) == 0 {
+			return nil, errors.New("The key is encrypted but no key store password was given")
+		}
+		var err error
+		der, err = x509.DecryptPEMBlock(block, pwd)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decrypt: %s", err)
+		}
+	}
+	block, err := x509.EncryptPEMBlock(rand.Reader, block.Type, der, newPwd, x509.PEMCipherAES256)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encrypt: %s", err)
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+func isKeyFile(name string) bool {
+	for _, suffix := range keyFileSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csp
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/hyperledger/fabric/bccsp"
+)
+
+// TestRekeyWriteFailure checks that a rekey which fails to write a key file
+// leaves every key in the key store encrypted with the old password
+func TestRekeyWriteFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rekey")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	ks := path.Join(dir, "ks")
+	sw := &SWConfig{KeyStoreDir: ks, Password: "oldpw", Ephemeral: true}
+	csp, err := sw.Get()
+	if err != nil {
+		t.Fatalf("Failed to get BCCSP instance: %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err = csp.KeyGen(&bccsp.ECDSAP256KeyGenOpts{Temporary: false})
+		if err != nil {
+			t.Fatalf("Failed to generate key: %s", err)
+		}
+	}
+	before := readKeyStore(ks, t)
+
+	writes := 0
+	writeFile = func(name string, buf []byte, perm os.FileMode) error {
+		writes++
+		if writes == 2 {
+			return errors.New("injected write failure")
+		}
+		return ioutil.WriteFile(name, buf, perm)
+	}
+	defer func() { writeFile = ioutil.WriteFile }()
+	err = sw.Rekey([]byte("newpw"))
+	if err == nil {
+		t.Fatal("Rekey should have failed to write a key file but didn't")
+	}
+
+	after := readKeyStore(ks, t)
+	if len(after) != len(before) {
+		t.Fatalf("The key store had %d files before the failed rekey and %d after", len(before), len(after))
+	}
+	for name, buf := range before {
+		if !bytes.Equal(after[name], buf) {
+			t.Errorf("Key file %s was changed by the failed rekey", name)
+		}
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read directory: %s", err)
+	}
+	if len(files) != 1 {
+		t.Errorf("The failed rekey left %d files next to the key store", len(files)-1)
+	}
+
+	// The rekey succeeds once the files can be written
+	writeFile = ioutil.WriteFile
+	err = sw.Rekey([]byte("newpw"))
+	if err != nil {
+		t.Fatalf("Failed to rekey key store: %s", err)
+	}
+	for name, buf := range readKeyStore(ks, t) {
+		_, err = rekeyPEM(buf, []byte("newpw"), []byte("newpw"))
+		if err != nil {
+			t.Errorf("Key file %s is not encrypted with the new password: %s", name, err)
+		}
+	}
+}
+
+// Return the contents of the files of a key store
+func readKeyStore(dir string, t *testing.T) map[string][]byte {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read key store: %s", err)
+	}
+	contents := map[string][]byte{}
+	for _, file := range files {
+		buf, err := ioutil.ReadFile(path.Join(dir, file.Name()))
+		if err != nil {
+			t.Fatalf("Failed to read key file: %s", err)
+		}
+		contents[file.Name()] = buf
+	}
+	return contents
+}
@@ -55,6 +55,19 @@ UPDATE users
 SELECT * FROM users
 	WHERE (id = ?)`
 
+	getGroupUsers = `
+SELECT * FROM users
+	WHERE (user_group = ? OR user_group LIKE ?)`
+
+	disableUser = `
+UPDATE users
+	SET state = -1
+	WHERE (id = ?)`
+
+	countUser = `
+SELECT COUNT(*) FROM users
+	WHERE (id = ?)`
+
 	insertGroup = `
 INSERT INTO groups (name, parent_id)
 	VALUES (?, ?)`
@@ -263,6 +276,73 @@ func (d *Accessor) GetUserInfo(id string) (spi.UserInfo, error) {
 	return userInfo, nil
 }
 
+// GetGroupUsers gets the users in a group and all of its subgroups from database
+func (d *Accessor) GetGroupUsers(name string) ([]spi.UserInfo, error) {
+	log.Debugf("DB: Get users in group (%s)", name)
+	err := d.checkDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var userRecs []UserRecord
+	err = d.db.Select(&userRecs, d.db.Rebind(getGroupUsers), name, name+".%")
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]spi.UserInfo, 0, len(userRecs))
+	for _, userRec := range userRecs {
+		// LIKE treats '_' as a wildcard, so make sure this is really a subgroup
+		if userRec.Group != name && !strings.HasPrefix(userRec.Group, name+".") {
+			continue
+		}
+		var attributes []api.Attribute
+		json.Unmarshal([]byte(userRec.Attributes), &attributes)
+		users = append(users, spi.UserInfo{
+			Name:           userRec.Name,
+			Pass:           userRec.Pass,
+			Type:           userRec.Type,
+			Group:          userRec.Group,
+			Attributes:     attributes,
+			State:          userRec.State,
+			MaxEnrollments: userRec.MaxEnrollments,
+		})
+	}
+
+	return users, nil
+}
+
+// disableUserTx disables a user as part of transaction 'tx' so that
+// the user can no longer enroll
+func disableUserTx(tx sqlx.Ext, id string) error {
+	log.Debugf("DB: Disable User (%s)", id)
+	res, err := tx.Exec(tx.Rebind(disableUser), id)
+	if err != nil {
+		return fmt.Errorf("Failed to disable user %s: %s", id, err)
+	}
+	numRowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numRowsAffected == 0 {
+		// Some databases (e.g. MySQL) only count rows which were changed, so a
+		// user who is already disabled is not counted; make sure the user exists
+		var count int
+		err = sqlx.Get(tx, &count, tx.Rebind(countUser), id)
+		if err != nil {
+			return fmt.Errorf("Failed to disable user %s: %s", id, err)
+		}
+		if count == 0 {
+			return fmt.Errorf("Failed to disable user %s: user not found", id)
+		}
+		return nil
+	}
+	if numRowsAffected != 1 {
+		return fmt.Errorf("Expected one user record to be disabled, but %d records were disabled", numRowsAffected)
+	}
+	return nil
+}
+
 // InsertGroup inserts group into database
 func (d *Accessor) InsertGroup(name string, parentID string) error {
 	log.Debugf("DB: Insert Group (%s)", name)
@@ -367,6 +447,11 @@ func (u *DBUser) GetName() string {
 	return u.Name
 }
 
+// GetType returns the type of the user
+func (u *DBUser) GetType() string {
+	return u.Type
+}
+
 // Login the user with a password
 func (u *DBUser) Login(pass string) error {
 	log.Debugf("DB: Login user %s with max enrollments of %d and state of %d", u.Name, u.MaxEnrollments, u.State)
@@ -376,6 +461,11 @@ func (u *DBUser) Login(pass string) error {
 		return errors.New("Incorrect password")
 	}
 
+	// A negative state means the user has been revoked
+	if u.State < 0 {
+		return newServerError(ErrCodeIdentityRevoked, "User %s is revoked", u.Name)
+	}
+
 	// If the maxEnrollments is set (i.e. >= 0), make sure we haven't exceeded this number of logins.
 	// The state variable keeps track of the number of previously successful logins.
 	if u.MaxEnrollments >= 0 {
@@ -383,7 +473,7 @@ func (u *DBUser) Login(pass string) error {
 		// If maxEnrollments is set to 0, user has unlimited enrollment
 		if u.MaxEnrollments != 0 {
 			if u.State >= u.MaxEnrollments {
-				return fmt.Errorf("The maximum number of enrollments is %d", u.MaxEnrollments)
+				return newServerError(ErrCodeMaxEnrollments, "The maximum number of enrollments is %d", u.MaxEnrollments)
 			}
 		}
 
@@ -88,6 +88,28 @@ func createSQLiteDBTables(datasource string) error {
 	}
 	log.Debug("Created certificates table")
 
+	if err := CreateArchiveTable(db); err != nil {
+		return err
+	}
+	log.Debug("Created certificates_archive table")
+
+	return nil
+}
+
+// CreateArchiveTable creates the certificates_archive table if it does not
+// already exist.  It has the same columns as the certificates table so that
+// expired certificates may be moved between the two.
+func CreateArchiveTable(db *sqlx.DB) error {
+	var query string
+	switch db.DriverName() {
+	case "mysql":
+		query = "CREATE TABLE IF NOT EXISTS certificates_archive (id VARCHAR(64), serial_number varbinary(20) NOT NULL, authority_key_identifier varbinary(128) NOT NULL, ca_label varbinary(128), status varbinary(128) NOT NULL, reason int, expiry timestamp DEFAULT '1970-01-01 00:00:01', revoked_at timestamp DEFAULT '1970-01-01 00:00:01', pem varbinary(4096) NOT NULL, PRIMARY KEY(serial_number, authority_key_identifier))"
+	default:
+		query = "CREATE TABLE IF NOT EXISTS certificates_archive (id VARCHAR(64), serial_number bytea NOT NULL, authority_key_identifier bytea NOT NULL, ca_label bytea, status bytea NOT NULL, reason int, expiry timestamp, revoked_at timestamp, pem bytea NOT NULL, PRIMARY KEY(serial_number, authority_key_identifier))"
+	}
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("Failed to create certificates_archive table: %s", err)
+	}
 	return nil
 }
 
@@ -101,7 +123,13 @@ func NewUserRegistryPostgres(datasource string, clientTLSConfig *tls.ClientTLSCo
 
 	connStr := getConnStr(datasource)
 
-	if clientTLSConfig != nil {
+	// The postgres driver builds its own TLS config from the connection
+	// string, which has no protocol settings
+	if clientTLSConfig != nil && clientTLSConfig.ProtocolConfig.IsSet() {
+		return nil, false, fmt.Errorf("The TLS preset, minversion, maxversion, ciphersuites and curves settings are not supported for a postgres database; set them on the postgres server instead")
+	}
+	clientTLSConfig = enableTLSIfConfigured(clientTLSConfig, postgresRequiresTLS(datasource), "postgres database")
+	if clientTLSConfig != nil && clientTLSConfig.Enabled {
 		if len(clientTLSConfig.CertFiles) > 0 {
 			root := clientTLSConfig.CertFiles[0]
 			connStr = fmt.Sprintf("%s sslrootcert=%s", connStr, root)
@@ -109,7 +137,9 @@ func NewUserRegistryPostgres(datasource string, clientTLSConfig *tls.ClientTLSCo
 
 		cert := clientTLSConfig.Client.CertFile
 		key := clientTLSConfig.Client.KeyFile
-		connStr = fmt.Sprintf("%s sslcert=%s sslkey=%s", connStr, cert, key)
+		if cert != "" || key != "" {
+			connStr = fmt.Sprintf("%s sslcert=%s sslkey=%s", connStr, cert, key)
+		}
 	}
 
 	log.Debug("Connection String: ", connStr)
@@ -180,6 +210,10 @@ func createPostgresDBTables(datasource string, dbName string, db *sqlx.DB) error
 		log.Errorf("Error creating certificates table [error: %s] ", err)
 		return err
 	}
+	if err := CreateArchiveTable(database); err != nil {
+		log.Errorf("Error creating certificates_archive table [error: %s] ", err)
+		return err
+	}
 	return nil
 }
 
@@ -194,13 +228,17 @@ func NewUserRegistryMySQL(datasource string, clientTLSConfig *tls.ClientTLSConfi
 	re := regexp.MustCompile(`\/([a-zA-z]+)`)
 	connStr := re.ReplaceAllString(datasource, "/")
 
-	if clientTLSConfig != nil {
+	clientTLSConfig = enableTLSIfConfigured(clientTLSConfig, mysqlRequiresTLS(datasource), "MySQL database")
+	if clientTLSConfig != nil && clientTLSConfig.Enabled {
 		tlsConfig, err := tls.GetClientTLSConfig(clientTLSConfig)
 		if err != nil {
-			log.Errorf("Failed to create TLS configuration [error: %s]", err)
+			return nil, false, fmt.Errorf("Failed to create TLS configuration: %s", err)
 		}
 
-		mysql.RegisterTLSConfig("custom", tlsConfig)
+		err = mysql.RegisterTLSConfig("custom", tlsConfig)
+		if err != nil {
+			return nil, false, fmt.Errorf("Failed to register TLS configuration: %s", err)
+		}
 	}
 
 	log.Debug("Connection String: ", connStr)
@@ -266,10 +304,43 @@ func createMySQLTables(datasource string, dbName string, db *sqlx.DB) error {
 		log.Errorf("Error creating certificates table [error: %s] ", err)
 		return err
 	}
+	if err := CreateArchiveTable(database); err != nil {
+		log.Errorf("Error creating certificates_archive table [error: %s] ", err)
+		return err
+	}
 
 	return nil
 }
 
+// enableTLSIfConfigured returns a copy of the TLS config of a database in
+// which TLS is enabled if certificate files are configured and the datasource
+// requires TLS, or the files are ignored if it does not, as for the server's
+// other TLS clients
+func enableTLSIfConfigured(clientTLSConfig *tls.ClientTLSConfig, secure bool, server string) *tls.ClientTLSConfig {
+	if clientTLSConfig == nil {
+		return nil
+	}
+	cfg := *clientTLSConfig
+	cfg.EnableIfConfigured(secure, server)
+	return &cfg
+}
+
+// postgresRequiresTLS returns true unless the sslmode of a postgres datasource
+// is "disable"; the postgres driver requires TLS by default
+func postgresRequiresTLS(datasource string) bool {
+	re := regexp.MustCompile(`sslmode=([^\s]+)`)
+	mode := re.FindStringSubmatch(datasource)
+	return mode == nil || mode[1] != "disable"
+}
+
+// mysqlRequiresTLS returns true if the "tls" parameter of a MySQL datasource
+// selects TLS
+func mysqlRequiresTLS(datasource string) bool {
+	re := regexp.MustCompile(`[?&]tls=([^&]+)`)
+	param := re.FindStringSubmatch(datasource)
+	return param != nil && param[1] != "false"
+}
+
 // GetDBName gets database name from connection string
 func getDBName(datasource string) string {
 	var dbName string
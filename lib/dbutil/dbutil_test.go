@@ -0,0 +1,74 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbutil
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-ca/lib/tls"
+)
+
+func TestEnableTLSIfConfigured(t *testing.T) {
+	postgres := map[string]bool{
+		"host=localhost dbname=fabric_ca":                      true,
+		"host=localhost dbname=fabric_ca sslmode=verify-full":  true,
+		"host=localhost dbname=fabric_ca sslmode=disable":      false,
+		"host=localhost sslmode=disable dbname=fabric_ca_test": false,
+	}
+	for datasource, secure := range postgres {
+		if postgresRequiresTLS(datasource) != secure {
+			t.Errorf("Expected postgres datasource '%s' to require TLS: %v", datasource, secure)
+		}
+	}
+	mysql := map[string]bool{
+		"root:rootpw@tcp(localhost:3306)/fabric_ca":                   false,
+		"root:rootpw@tcp(localhost:3306)/fabric_ca?tls=custom":        true,
+		"root:rootpw@tcp(localhost:3306)/fabric_ca?parseTime=1&tls=1": true,
+		"root:rootpw@tcp(localhost:3306)/fabric_ca?tls=false":         false,
+	}
+	for datasource, secure := range mysql {
+		if mysqlRequiresTLS(datasource) != secure {
+			t.Errorf("Expected MySQL datasource '%s' to require TLS: %v", datasource, secure)
+		}
+	}
+
+	// The certificate files of a secure datasource enable TLS, and are
+	// otherwise ignored, without changing the config of the caller
+	cfg := &tls.ClientTLSConfig{CertFiles: []string{"root.pem"}}
+	enabled := enableTLSIfConfigured(cfg, true, "postgres database")
+	if !enabled.Enabled || len(enabled.CertFiles) != 1 {
+		t.Errorf("Expected TLS to be enabled with the certificate files: %+v", enabled)
+	}
+	ignored := enableTLSIfConfigured(cfg, false, "postgres database")
+	if ignored.Enabled || len(ignored.CertFiles) != 0 {
+		t.Errorf("Expected the certificate files to be ignored: %+v", ignored)
+	}
+	if cfg.Enabled || len(cfg.CertFiles) != 1 {
+		t.Errorf("The TLS config of the caller was changed: %+v", cfg)
+	}
+	if enableTLSIfConfigured(nil, true, "postgres database") != nil {
+		t.Error("Expected no TLS config without a TLS config")
+	}
+
+	// The certificate files are loaded for a MySQL database with TLS even
+	// though TLS is not enabled in its TLS config
+	_, _, err := NewUserRegistryMySQL("root:rootpw@tcp(localhost:3306)/fabric_ca?tls=custom", &tls.ClientTLSConfig{CertFiles: []string{"missing.pem"}})
+	if err == nil || !strings.Contains(err.Error(), "Failed to create TLS configuration") {
+		t.Errorf("Expected the missing certificate file of a MySQL database to fail: %v", err)
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package lib
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -26,10 +27,9 @@ import (
 	"github.com/hyperledger/fabric-ca/api"
 	"github.com/hyperledger/fabric-ca/util"
 	"github.com/hyperledger/fabric/bccsp"
-	"github.com/hyperledger/fabric/bccsp/factory"
 )
 
-func newIdentity(client *Client, name string, key []byte, cert []byte) *Identity {
+func newIdentity(client *Client, name string, key bccsp.Key, cert []byte) *Identity {
 	id := new(Identity)
 	id.name = name
 	id.ecert = newSigner(key, cert, id)
@@ -43,6 +43,8 @@ type Identity struct {
 	ecert  *Signer
 	client *Client
 	CSP    bccsp.BCCSP
+	// The chain of the CA which issued the ECert
+	caChain []byte
 }
 
 // GetName returns the identity name
@@ -57,11 +59,17 @@ func (i *Identity) GetECert() *Signer {
 
 // GetTCertBatch returns a batch of TCerts for this identity
 func (i *Identity) GetTCertBatch(req *api.GetTCertBatchRequest) ([]*Signer, error) {
+	return i.GetTCertBatchContext(context.Background(), req)
+}
+
+// GetTCertBatchContext returns a batch of TCerts for this identity.  The
+// request is canceled when the context is canceled or its deadline expires.
+func (i *Identity) GetTCertBatchContext(ctx context.Context, req *api.GetTCertBatchRequest) ([]*Signer, error) {
 	reqBody, err := util.Marshal(req, "GetTCertBatchRequest")
 	if err != nil {
 		return nil, err
 	}
-	_, err2 := i.Post("tcert", reqBody)
+	_, err2 := i.PostContext(ctx, "tcert", reqBody)
 	if err2 != nil {
 		return nil, err2
 	}
@@ -74,6 +82,14 @@ func (i *Identity) GetTCertBatch(req *api.GetTCertBatchRequest) ([]*Signer, erro
 // Register registers a new identity
 // @param req The registration request
 func (i *Identity) Register(req *api.RegistrationRequest) (rr *api.RegistrationResponse, err error) {
+	return i.RegisterContext(context.Background(), req)
+}
+
+// RegisterContext registers a new identity.  The request is canceled when
+// the context is canceled or its deadline expires.
+// @param ctx The context of the request
+// @param req The registration request
+func (i *Identity) RegisterContext(ctx context.Context, req *api.RegistrationRequest) (rr *api.RegistrationResponse, err error) {
 	var reqBody []byte
 	var secret string
 	var resp interface{}
@@ -92,7 +108,7 @@ func (i *Identity) Register(req *api.RegistrationRequest) (rr *api.RegistrationR
 	}
 
 	// Send a post to the "register" endpoint with req as body
-	resp, err = i.Post("register", reqBody)
+	resp, err = i.PostContext(ctx, "register", reqBody)
 	if err != nil {
 		return nil, err
 	}
@@ -113,64 +129,132 @@ func (i *Identity) Register(req *api.RegistrationRequest) (rr *api.RegistrationR
 // Reenroll reenrolls an existing Identity and returns a new Identity
 // @param req The reenrollment request
 func (i *Identity) Reenroll(req *api.ReenrollmentRequest) (*Identity, error) {
+	return i.ReenrollContext(context.Background(), req)
+}
+
+// ReenrollContext reenrolls an existing Identity and returns a new Identity.
+// The request is canceled when the context is canceled or its deadline
+// expires.
+// @param ctx The context of the request
+// @param req The reenrollment request
+func (i *Identity) ReenrollContext(ctx context.Context, req *api.ReenrollmentRequest) (*Identity, error) {
 	log.Debugf("Reenrolling %s", req)
 
-	csrPEM, key, err := i.client.GenCSR(req.CSR, i.GetName())
+	csrPEM, key, err := i.client.GenCSRWithBCCSP(req.CSR, i.GetName())
 	if err != nil {
 		return nil, err
 	}
 
 	// Get the body of the request
-	sreq := signer.SignRequest{
-		Hosts:   signer.SplitHosts(req.Hosts),
-		Request: string(csrPEM),
-		Profile: req.Profile,
-		Label:   req.Label,
+	reqNet := &api.ReenrollmentRequestNet{
+		SignRequest: signer.SignRequest{
+			Hosts:   signer.SplitHosts(req.Hosts),
+			Request: string(csrPEM),
+			Profile: req.Profile,
+			Label:   req.Label,
+		},
+		AttrReqs: req.AttrReqs,
+		CAName:   req.CAName,
 	}
-	body, err := util.Marshal(sreq, "SignRequest")
+	body, err := util.Marshal(reqNet, "SignRequest")
 	if err != nil {
 		return nil, err
 	}
 
-	result, err := i.Post("reenroll", body)
+	result, err := i.PostContext(ctx, "reenroll", body)
 	if err != nil {
 		return nil, err
 	}
 
-	return i.client.newIdentityFromResponse(result, i.GetName(), key)
+	return i.client.newIdentityFromResponse(ctx, result, i.GetName(), key, req.CAName)
 }
 
 // Revoke the identity associated with 'id'
+// @param req The revocation request
 func (i *Identity) Revoke(req *api.RevocationRequest) error {
+	_, err := i.RevokeWithResponse(req)
+	return err
+}
+
+// RevokeContext revokes the identity associated with 'id'.  The request is
+// canceled when the context is canceled or its deadline expires.
+// @param ctx The context of the request
+// @param req The revocation request
+func (i *Identity) RevokeContext(ctx context.Context, req *api.RevocationRequest) error {
+	_, err := i.RevokeWithResponseContext(ctx, req)
+	return err
+}
+
+// RevokeWithResponse revokes the identity associated with 'id' and returns
+// the identities and certificates which were revoked, or which would have
+// been revoked if req.DryRun is true
+// @param req The revocation request
+func (i *Identity) RevokeWithResponse(req *api.RevocationRequest) (*api.RevocationResponse, error) {
+	return i.RevokeWithResponseContext(context.Background(), req)
+}
+
+// RevokeWithResponseContext is RevokeWithResponse with a context which
+// cancels the request when it is canceled or its deadline expires.
+// @param ctx The context of the request
+// @param req The revocation request
+func (i *Identity) RevokeWithResponseContext(ctx context.Context, req *api.RevocationRequest) (*api.RevocationResponse, error) {
 	log.Debugf("Entering identity.Revoke %+v", req)
 	reqBody, err := util.Marshal(req, "RevocationRequest")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	_, err = i.Post("revoke", reqBody)
+	result, err := i.PostContext(ctx, "revoke", reqBody)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	log.Debugf("Successfully revoked %+v", req)
-	return nil
+	resp := &api.RevocationResponse{}
+	if result != nil {
+		// Convert the generic result into a RevocationResponse
+		var buf []byte
+		buf, err = util.Marshal(result, "RevocationResponse")
+		if err != nil {
+			return nil, err
+		}
+		err = util.Unmarshal(buf, resp, "RevocationResponse")
+		if err != nil {
+			return nil, err
+		}
+	}
+	log.Debugf("Successfully revoked %+v; response: %+v", req, resp)
+	return resp, nil
 }
 
 // RevokeSelf revokes the current identity and all certificates
 func (i *Identity) RevokeSelf() error {
+	_, err := i.RevokeSelfWithResponse()
+	return err
+}
+
+// RevokeSelfWithResponse revokes the current identity and all certificates,
+// and returns the identities and certificates which were revoked
+func (i *Identity) RevokeSelfWithResponse() (*api.RevocationResponse, error) {
 	name := i.GetName()
 	log.Debugf("RevokeSelf %s", name)
 	req := &api.RevocationRequest{
 		Name: name,
 	}
-	return i.Revoke(req)
+	return i.RevokeWithResponse(req)
 }
 
-// Store writes my identity info to disk
+// Store writes my identity info, and the chain of the CA which issued it, to the
+// client's MSP directory
 func (i *Identity) Store() error {
 	if i.client == nil {
 		return fmt.Errorf("An identity with no client may not be stored")
 	}
-	return i.client.StoreMyIdentity(i.ecert.key, i.ecert.cert)
+	err := i.client.StoreMyCert(i.ecert.cert)
+	if err != nil {
+		return err
+	}
+	if len(i.caChain) == 0 {
+		return nil
+	}
+	return i.client.StoreCAChain(i.caChain)
 }
 
 // Post sends arbtrary request body (reqBody) to an endpoint.
@@ -178,7 +262,14 @@ func (i *Identity) Store() error {
 // of this identity over the body and non-signature part of the authorization header.
 // The return value is the body of the response.
 func (i *Identity) Post(endpoint string, reqBody []byte) (interface{}, error) {
-	req, err := i.client.NewPost(endpoint, reqBody)
+	return i.PostContext(context.Background(), endpoint, reqBody)
+}
+
+// PostContext sends arbitrary request body (reqBody) to an endpoint, like
+// Post.  The request is canceled when the context is canceled or its
+// deadline expires.
+func (i *Identity) PostContext(ctx context.Context, endpoint string, reqBody []byte) (interface{}, error) {
+	req, err := i.client.NewPostContext(ctx, endpoint, reqBody)
 	if err != nil {
 		return nil, err
 	}
@@ -194,28 +285,16 @@ func (i *Identity) addTokenAuthHdr(req *http.Request, body []byte) error {
 	cert := i.ecert.cert
 	key := i.ecert.key
 	if i.CSP == nil {
-		csp, error := getDefaultBCCSPInstance()
-		if error != nil {
-			return fmt.Errorf("Default BCCSP instance failed with error : %s", error)
+		csp, err := i.client.GetCSP()
+		if err != nil {
+			return err
 		}
 		i.CSP = csp
 	}
-	token, err := util.CreateToken(i.CSP, cert, key, body)
+	token, err := util.CreateTokenWithKey(i.CSP, cert, key, body)
 	if err != nil {
 		return fmt.Errorf("Failed to add token authorization header: %s", err)
 	}
 	req.Header.Set("authorization", token)
 	return nil
 }
-
-func getDefaultBCCSPInstance() (bccsp.BCCSP, error) {
-	defaultBccsp, bccspError := factory.GetDefault()
-	if bccspError != nil {
-		return nil, fmt.Errorf("BCCSP initialiazation failed with error : %s", bccspError)
-	}
-	if defaultBccsp == nil {
-		return nil, errors.New("Cannot get default instance of BCCSP")
-	}
-
-	return defaultBccsp, nil
-}
@@ -22,9 +22,8 @@ import (
 )
 
 func getIdentity() *Identity {
-	key, _ := ioutil.ReadFile("../tesdata/ec-key.pem")
 	cert, _ := ioutil.ReadFile("../tesdata/ec.pem")
-	id := newIdentity(nil, "test", key, cert)
+	id := newIdentity(nil, "test", nil, cert)
 	return id
 }
 
@@ -27,6 +27,7 @@ import (
 
 	"github.com/cloudflare/cfssl/log"
 	"github.com/hyperledger/fabric-ca/lib/spi"
+	fcatls "github.com/hyperledger/fabric-ca/lib/tls"
 	ldap "gopkg.in/ldap.v2"
 )
 
@@ -43,6 +44,10 @@ type Config struct {
 	Base        string `json:"base,omitempty"`
 	UserFilter  string `json:"userfilter,omitempty"`
 	GroupFilter string `json:"groupfilter,omitempty"`
+	// TLS is the TLS config of "ldaps" connections; it is enabled by setting
+	// its certificate files, and otherwise the system's root certificates
+	// verify the server
+	TLS fcatls.ClientTLSConfig `json:"tls,omitempty"`
 }
 
 // NewClient creates an LDAP client
@@ -95,6 +100,16 @@ func NewClient(cfg *Config) (*Client, error) {
 	}
 	c.UserFilter = cfgVal(cfg.UserFilter, "(uid=%s)")
 	c.GroupFilter = cfgVal(cfg.GroupFilter, "(memberUid=%s)")
+	c.TLS = cfg.TLS
+	if c.TLS.Enabled && !c.UseSSL {
+		return nil, fmt.Errorf("LDAP TLS is enabled, but the LDAP URL scheme is not 'ldaps'")
+	}
+	c.TLS.EnableIfConfigured(c.UseSSL, "LDAP server")
+	// Validate the TLS config now rather than on the first connection
+	_, err = c.tlsConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP TLS config: %s", err)
+	}
 	log.Debug("LDAP client was successfully created")
 	return c, nil
 }
@@ -116,6 +131,7 @@ type Client struct {
 	Base          string
 	UserFilter    string // e.g. "(uid=%s)"
 	GroupFilter   string // e.g. "(memberUid=%s)"
+	TLS           fcatls.ClientTLSConfig
 	AdminConn     *ldap.Conn
 }
 
@@ -182,6 +198,11 @@ func (lc *Client) GetUserInfo(id string) (spi.UserInfo, error) {
 	return userInfo, errNotSupported
 }
 
+// GetGroupUsers returns the users in an affiliation group and all of its subgroups
+func (lc *Client) GetGroupUsers(name string) ([]spi.UserInfo, error) {
+	return nil, errNotSupported
+}
+
 // InsertUser inserts a user
 func (lc *Client) InsertUser(user spi.UserInfo) error {
 	return errNotSupported
@@ -229,6 +250,15 @@ func (lc *Client) adminConnect() error {
 	return nil
 }
 
+// Close the cached admin connection to the LDAP server, if any
+func (lc *Client) Close() error {
+	if lc.AdminConn != nil {
+		lc.AdminConn.Close()
+		lc.AdminConn = nil
+	}
+	return nil
+}
+
 // Connect to the LDAP server and bind as user as admin user as specified in LDAP URL
 func (lc *Client) newConnection() (conn *ldap.Conn, err error) {
 	address := fmt.Sprintf("%s:%d", lc.Host, lc.Port)
@@ -240,7 +270,12 @@ func (lc *Client) newConnection() (conn *ldap.Conn, err error) {
 		}
 	} else {
 		log.Debug("Connecting to LDAP server over TLS")
-		conn, err = ldap.DialTLS("tcp", address, &tls.Config{ServerName: lc.Host})
+		var tlsConfig *tls.Config
+		tlsConfig, err = lc.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		conn, err = ldap.DialTLS("tcp", address, tlsConfig)
 		if err != nil {
 			return conn, fmt.Errorf("Failed to connect to LDAP server over TLS at %s: %s", address, err)
 		}
@@ -256,6 +291,26 @@ func (lc *Client) newConnection() (conn *ldap.Conn, err error) {
 	return conn, nil
 }
 
+// tlsConfig returns the TLS config of connections to the LDAP server
+func (lc *Client) tlsConfig() (*tls.Config, error) {
+	if lc.TLS.Enabled {
+		config, err := fcatls.GetClientTLSConfig(&lc.TLS)
+		if err != nil {
+			return nil, err
+		}
+		if config.ServerName == "" {
+			config.ServerName = lc.Host
+		}
+		return config, nil
+	}
+	config := &tls.Config{ServerName: lc.Host}
+	err := fcatls.SetProtocol(config, &lc.TLS.ProtocolConfig)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
 // User represents a single user
 type User struct {
 	name   string
@@ -269,6 +324,11 @@ func (u *User) GetName() string {
 	return u.dn
 }
 
+// GetType returns the type of the user, which is not known to LDAP
+func (u *User) GetType() string {
+	return ""
+}
+
 // Login logs a user in using password
 func (u *User) Login(password string) error {
 
@@ -0,0 +1,164 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/signer"
+	"github.com/hyperledger/fabric-ca/api"
+	"github.com/hyperledger/fabric-ca/lib/dbutil"
+	"github.com/hyperledger/fabric-ca/lib/spi"
+)
+
+// ldapStyleRegistry is a registry which behaves like the LDAP client: it
+// only returns the attributes which are asked for, an identity's affiliation
+// path is its DN, and it can not be updated
+type ldapStyleRegistry struct {
+	spi.UserRegistry
+	users map[string]*ldapStyleUser
+}
+
+type ldapStyleUser struct {
+	dn    string
+	attrs map[string]string
+}
+
+func (r *ldapStyleRegistry) GetUser(id string, attrNames []string) (spi.User, error) {
+	u, found := r.users[id]
+	if !found {
+		return nil, errors.New("User not found")
+	}
+	attrs := map[string]string{}
+	for _, name := range attrNames {
+		if val, found := u.attrs[name]; found {
+			attrs[name] = val
+		}
+	}
+	return &ldapStyleUser{dn: u.dn, attrs: attrs}, nil
+}
+
+func (u *ldapStyleUser) GetName() string {
+	return u.dn
+}
+
+func (u *ldapStyleUser) GetType() string {
+	return ""
+}
+
+func (u *ldapStyleUser) Login(password string) error {
+	return errors.New("Not supported")
+}
+
+func (u *ldapStyleUser) GetAffiliationPath() []string {
+	parts := strings.Split(u.dn, ",")
+	path := make([]string, len(parts))
+	for i, part := range parts {
+		path[len(parts)-1-i] = part
+	}
+	return path
+}
+
+func (u *ldapStyleUser) GetAttribute(name string) string {
+	return u.attrs[name]
+}
+
+func TestAuthorizeSignRequestLDAP(t *testing.T) {
+	ca := &CA{
+		Config: &CAConfig{},
+		registry: &ldapStyleRegistry{users: map[string]*ldapStyleUser{
+			"peer1": {
+				dn: "uid=peer1,ou=peers,dc=example,dc=com",
+				attrs: map[string]string{
+					"hf.AllowedHosts":    "peer1.example.com",
+					"hf.AllowedProfiles": "tls",
+					"app.role":           "peer",
+				},
+			},
+		}},
+	}
+	req := &api.EnrollmentRequestNet{
+		SignRequest: signer.SignRequest{Hosts: []string{"peer1.example.com"}, Profile: "tls"},
+		AttrReqs:    []*api.AttributeRequest{{Name: "app.role", Require: true}},
+	}
+	err := ca.authorizeSignRequest("peer1", req)
+	if err != nil {
+		t.Fatalf("Failed to authorize the sign request of an LDAP identity: %s", err)
+	}
+	if len(req.Extensions) != 1 {
+		t.Errorf("Expected the attribute extension but found %d extensions", len(req.Extensions))
+	}
+
+	req = &api.EnrollmentRequestNet{
+		SignRequest: signer.SignRequest{Hosts: []string{"peer2.example.com"}},
+	}
+	err = ca.authorizeSignRequest("peer1", req)
+	if err == nil {
+		t.Error("Sign request for a host which the LDAP identity is not allowed should have failed")
+	}
+}
+
+func TestRevokeLDAP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revokeldap")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	db, _, err := dbutil.NewUserRegistrySQLLite3(filepath.Join(dir, "fabric-ca-server.db"))
+	if err != nil {
+		t.Fatalf("Failed to create database: %s", err)
+	}
+	defer db.Close()
+	_, err = db.Exec("INSERT INTO certificates (id, serial_number, authority_key_identifier, ca_label, status, reason, expiry, revoked_at, pem) VALUES ('peer1', '01', 'aa', '', 'good', 0, ?, ?, 'pem')", time.Now().Add(time.Hour), time.Time{})
+	if err != nil {
+		t.Fatalf("Failed to insert certificate: %s", err)
+	}
+
+	// The revoker's DN is not a prefix of the owner's DN, so the affiliation
+	// check of the database registry would have rejected the revoke
+	ca := &CA{
+		Config:         &CAConfig{},
+		certDBAccessor: NewCertDBAccessor(db),
+		registry: &ldapStyleRegistry{users: map[string]*ldapStyleUser{
+			"admin": {dn: "uid=admin,ou=admins,dc=example,dc=com"},
+			"peer1": {dn: "uid=peer1,ou=peers,dc=example,dc=com"},
+		}},
+	}
+	result, err := ca.revokeCertificate("admin", "01", "aa", 0, false)
+	if err != nil {
+		t.Fatalf("Failed to revoke the certificate of an LDAP identity: %s", err)
+	}
+	if len(result.RevokedCerts) != 1 {
+		t.Errorf("Expected 1 revoked certificate but found %d", len(result.RevokedCerts))
+	}
+
+	// Revoking by name or affiliation would disable identities in the users
+	// table, which does not hold the LDAP identities
+	err = ca.checkIdentitiesRevocable()
+	if err == nil {
+		t.Fatal("Revoking LDAP identities by name or affiliation should have failed")
+	}
+	if serr, ok := err.(*ServerError); !ok || serr.Code != ErrCodeBadRequest {
+		t.Errorf("Expected a bad request error but found: %s", err)
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/cloudflare/cfssl/signer"
+	"github.com/hyperledger/fabric-ca/lib/spi"
+	"github.com/hyperledger/fabric/bccsp"
+)
+
+// FIXME: These variables are temporary and will be removed once
+// the cobra/viper move is complete and we no longer support the fabric command.
+// They are set by the fabric command, whose single CA is built from them
+// for each request; the fabric-ca server keeps the state of each of its
+// CAs in a CA object instead.
+var (
+	EnrollSigner     signer.Signer
+	UserRegistry     spi.UserRegistry
+	MaxEnrollments   int
+	MyCertDBAccessor *CertDBAccessor
+	CAKeyFile        string
+	CACertFile       string
+	MyCSP            bccsp.BCCSP
+)
+
+// NewAuthWrapper is the auth wrapper constructor used by the fabric command.
+// Only the "enroll" URI uses basic auth for the enrollment secret, while all
+// others require a token which proves ownership of an ecert.
+func NewAuthWrapper(path string, handler http.Handler, err error) (string, http.Handler, error) {
+	return newAuthWrapper(path, handler, getLegacyCA, false, err)
+}
+
+// NewRegisterHandler is the register handler constructor used by the fabric command
+func NewRegisterHandler() (http.Handler, error) {
+	return newRegisterHandler(getLegacyCA)
+}
+
+// NewEnrollHandler is the enroll handler constructor used by the fabric command
+func NewEnrollHandler() (http.Handler, error) {
+	return newEnrollHandler(getLegacyCA)
+}
+
+// NewReenrollHandler is the reenroll handler constructor used by the fabric command
+func NewReenrollHandler() (http.Handler, error) {
+	return newReenrollHandler(getLegacyCA)
+}
+
+// NewRevokeHandler is the revoke handler constructor used by the fabric command
+func NewRevokeHandler() (http.Handler, error) {
+	return newRevokeHandler(getLegacyCA)
+}
+
+// NewTCertHandler is the tcert handler constructor used by the fabric command
+func NewTCertHandler() (http.Handler, error) {
+	ca := newLegacyCA()
+	err := ca.initTCert()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to initialize TCert handler: %s", err)
+	}
+	return newTCertHandler(func(r *http.Request) (*CA, error) {
+		return ca, nil
+	})
+}
+
+// NewCAInfoHandler is the cainfo handler constructor used by the fabric command,
+// whose CA chain is its certificate
+func NewCAInfoHandler() (http.Handler, error) {
+	ca := newLegacyCA()
+	chain, err := ioutil.ReadFile(CACertFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read CA certificate: %s", err)
+	}
+	ca.chain = chain
+	return newCAInfoHandler(func(r *http.Request) (*CA, error) {
+		return ca, nil
+	})
+}
+
+// getLegacyCA returns the fabric command's CA for any request
+func getLegacyCA(r *http.Request) (*CA, error) {
+	return newLegacyCA(), nil
+}
+
+// newLegacyCA returns the fabric command's CA, which is built from the
+// variables set by the fabric command
+func newLegacyCA() *CA {
+	return &CA{
+		Config: &CAConfig{
+			CA: ServerConfigCA{
+				Keyfile:  CAKeyFile,
+				Certfile: CACertFile,
+			},
+			Registry: ServerConfigRegistry{
+				MaxEnrollments: MaxEnrollments,
+			},
+		},
+		csp:            MyCSP,
+		certDBAccessor: MyCertDBAccessor,
+		registry:       UserRegistry,
+		enrollSigner:   EnrollSigner,
+	}
+}
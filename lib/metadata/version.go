@@ -0,0 +1,29 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metadata contains information about the build of fabric-ca
+package metadata
+
+// Version is the version of fabric-ca, which is set at build time
+var Version string
+
+// GetVersion returns the version of fabric-ca
+func GetVersion() string {
+	if Version == "" {
+		return "development build"
+	}
+	return Version
+}
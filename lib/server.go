@@ -17,7 +17,10 @@ limitations under the License.
 package lib
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -26,41 +29,20 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
+	"sync/atomic"
 
 	"github.com/cloudflare/cfssl/config"
-	"github.com/cloudflare/cfssl/csr"
-	"github.com/cloudflare/cfssl/initca"
 	"github.com/cloudflare/cfssl/log"
-	"github.com/cloudflare/cfssl/signer"
-	"github.com/cloudflare/cfssl/signer/universal"
-	"github.com/hyperledger/fabric-ca/api"
-	libcsp "github.com/hyperledger/fabric-ca/lib/csp"
-	"github.com/hyperledger/fabric-ca/lib/dbutil"
-	"github.com/hyperledger/fabric-ca/lib/ldap"
-	"github.com/hyperledger/fabric-ca/lib/spi"
+	"github.com/hyperledger/fabric-ca/lib/attrmgr"
+	libtls "github.com/hyperledger/fabric-ca/lib/tls"
 	"github.com/hyperledger/fabric-ca/util"
-	"github.com/hyperledger/fabric/bccsp"
-	"github.com/jmoiron/sqlx"
 
 	_ "github.com/go-sql-driver/mysql" // import to support MySQL
 	_ "github.com/lib/pq"              // import to support Postgres
 	_ "github.com/mattn/go-sqlite3"    // import to support SQLite3
 )
 
-// FIXME: These variables are temporary and will be removed once
-// the cobra/viper move is complete and we no longer support the fabric command.
-// The correct way is to pass the Server object (and thus ServerConfig)
-// to the endpoint handler constructors, thus using no global variables.
-var (
-	EnrollSigner     signer.Signer
-	UserRegistry     spi.UserRegistry
-	MaxEnrollments   int
-	MyCertDBAccessor *CertDBAccessor
-	CAKeyFile        string
-	CACertFile       string
-	MyCSP            bccsp.BCCSP
-)
-
 // Server is the fabric-ca server
 type Server struct {
 	// The home directory for the server
@@ -70,24 +52,37 @@ type Server struct {
 	BlockingStart bool
 	// The server's configuration
 	Config *ServerConfig
-	// The database handle used to store certificates and optionally
-	// the user registry information, unless LDAP it enabled for the
-	// user registry function.
-	db *sqlx.DB
-	// The crypto service provider (BCCSP)
-	csp bccsp.BCCSP
-	// The certificate DB accessor
-	certDBAccessor *CertDBAccessor
-	// The user registry
-	registry spi.UserRegistry
-	// The signer used for enrollment
-	enrollSigner signer.Signer
+	// The server's default CA, which serves requests which don't name a CA
+	CA
+	// The additional CAs hosted by the server, by name
+	caMap map[string]*CA
 	// The server mux
 	mux *http.ServeMux
 	// The current listener for this server
 	listener net.Listener
+	// The HTTP server which serves requests from the listener
+	httpServer *http.Server
+	// Closed when Stop has drained active requests and closed the CAs
+	stopped chan struct{}
 	// An error which occurs when serving
 	serveError error
+	// Closed to stop archiving expired certificates in the background
+	archiverStop chan struct{}
+	// Closed to stop renewing the TLS certificate in the background
+	tlsRenewerStop chan struct{}
+	// Waits for the background archiving and TLS renewal loops to return
+	background sync.WaitGroup
+	// Serializes calls to Stop
+	stopMutex sync.Mutex
+	// Waits for the handlers of active requests to return
+	handlers sync.WaitGroup
+	// Requests are served holding the read lock, and a reload of the config
+	// takes the write lock to apply the new config atomically
+	configMutex sync.RWMutex
+	// Serializes reloads of the config
+	reloadMutex sync.Mutex
+	// The server's TLS certificate, which a reload replaces
+	tlsCert atomic.Value
 }
 
 // Init initializes a fabric-ca server
@@ -97,27 +92,26 @@ func (s *Server) Init(renew bool) (err error) {
 	if err != nil {
 		return err
 	}
-	// Initialize the Crypto Service Provider
-	s.csp, err = libcsp.Get(s.Config.CSP)
-	MyCSP = s.csp
+	// Initialize the default CA
+	err = s.CA.init(renew)
 	if err != nil {
-		log.Errorf("Failed to get the crypto service provider: %s", err)
 		return err
 	}
-	// Initialize key materials
-	err = s.initKeyMaterial(renew)
+	// Issue the server's TLS certificate from the default CA if needed
+	err = s.initTLSCert()
 	if err != nil {
 		return err
 	}
-	// Initialize the database
-	err = s.initDB()
+	// Initialize the additional CAs
+	err = s.loadCAs()
 	if err != nil {
 		return err
 	}
-	// Initialize the enrollment signer
-	err = s.initEnrollmentSigner()
-	if err != nil {
-		return err
+	for name, ca := range s.caMap {
+		err = ca.init(renew)
+		if err != nil {
+			return fmt.Errorf("Failed to initialize CA '%s': %s", name, err)
+		}
 	}
 	// Successful initialization
 	return nil
@@ -138,85 +132,75 @@ func (s *Server) Start() (err error) {
 		return err
 	}
 
-	// TEMP
-	CAKeyFile = s.Config.CA.Keyfile
-	CACertFile = s.Config.CA.Certfile
-
 	// Register http handlers
 	s.registerHandlers()
 
+	// Start archiving expired certificates if configured
+	s.startArchiver()
+
+	// Start renewing the TLS certificate which the server issued for itself
+	s.startTLSRenewer()
+
 	// Start listening and serving
-	return s.listenAndServe()
+	err = s.listenAndServe()
+	if err != nil {
+		s.stopArchiver()
+		s.stopTLSRenewer()
+		s.background.Wait()
+	}
+	return err
 
 }
 
-// Stop the server
-// WARNING: This forcefully closes the listening socket and may cause
-// requests in transit to fail, and so is only used for testing.
-// A graceful shutdown will be supported with golang 1.8.
+// Stop the server gracefully.  The server stops accepting connections and
+// waits up to the configured shutdown timeout for active requests to
+// complete, after which their connections are closed.  The databases, LDAP
+// connections and key stores of the CAs are then closed.  A blocking Start
+// returns once Stop is done.  Concurrent calls are serialized, and all but
+// the first return an error.
 func (s *Server) Stop() error {
+	s.stopMutex.Lock()
+	defer s.stopMutex.Unlock()
 	if s.listener == nil {
 		return errors.New("server is not currently started")
 	}
-	err := s.listener.Close()
-	s.listener = nil
-	return err
-}
-
-// Initialize the fabric-ca server's key material
-func (s *Server) initKeyMaterial(renew bool) error {
-	log.Debugf("Init with home %s and config %+v", s.HomeDir, s.Config)
-
-	// Make the path names absolute in the config
-	s.makeFileNamesAbsolute()
-
-	keyFile := s.Config.CA.Keyfile
-	certFile := s.Config.CA.Certfile
-
-	// If we aren't renewing and the key and cert files exist, do nothing
-	if !renew {
-		// If they both exist, the server was already initialized
-		keyFileExists := util.FileExists(keyFile)
-		certFileExists := util.FileExists(certFile)
-		if keyFileExists && certFileExists {
-			log.Info("The CA key and certificate files already exist")
-			log.Infof("Key file location: %s", keyFile)
-			log.Infof("Certificate file location: %s", certFile)
-			return nil
-		}
-	}
-
-	// Create the certificate request, copying from config
-	ptr := &s.Config.CSR
-	req := csr.CertificateRequest{
-		CN:    ptr.CN,
-		Names: ptr.Names,
-		Hosts: ptr.Hosts,
-		// FIXME: NewBasicKeyRequest only does ecdsa 256; use config
-		KeyRequest:   csr.NewBasicKeyRequest(),
-		CA:           ptr.CA,
-		SerialNumber: ptr.SerialNumber,
-	}
-
-	// Initialize the CA now
-	cert, _, key, err := initca.New(&req)
-	if err != nil {
-		return fmt.Errorf("Failed to initialize CA [%s]\nRequest was %#v", err, req)
-	}
-
-	// Store the key and certificate to file
-	err = writeFile(keyFile, key, 0600)
+	// The background loops use the CAs, so they must return before the CAs
+	// are closed
+	s.stopArchiver()
+	s.stopTLSRenewer()
+	s.background.Wait()
+	timeout := s.Config.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	log.Infof("Stopping the server; waiting up to %s for active requests to complete", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// Shutdown closes the listener and idle connections, so that clients
+	// which keep their connections alive do not send requests to the
+	// stopped server, and then waits for active requests
+	err := s.httpServer.Shutdown(ctx)
 	if err != nil {
-		return fmt.Errorf("Failed to store key: %s", err)
+		log.Warningf("Active requests did not complete in %s; closing their connections", timeout)
+		err = s.httpServer.Close()
+		// Closing the connections does not stop their handlers, which may
+		// still be using the CAs
+		s.handlers.Wait()
 	}
-	err = writeFile(certFile, cert, 0644)
-	if err != nil {
-		return fmt.Errorf("Failed to store certificate: %s", err)
+	s.listener = nil
+	s.httpServer = nil
+	for _, ca := range s.getCAs() {
+		cerr := ca.close()
+		if cerr != nil {
+			log.Errorf("Failed to close CA '%s': %s", ca.Config.CA.Name, cerr)
+			if err == nil {
+				err = cerr
+			}
+		}
 	}
-	log.Info("The CA key and certificate files were generated")
-	log.Infof("Key file location: %s", keyFile)
-	log.Infof("Certificate file location: %s", certFile)
-	return nil
+	close(s.stopped)
+	log.Info("The server has stopped")
+	return err
 }
 
 // RegisterBootstrapUser registers the bootstrap user with appropriate privileges
@@ -235,7 +219,7 @@ func (s *Server) RegisterBootstrapUser(user, pass, affiliation string) error {
 		Type:        "user",
 		Affiliation: affiliation,
 		Attributes: map[string]string{
-			"hf.Registrar.Roles":         "client,user,peer,validator,auditor",
+			"hf.Registrar.Roles":         "client,user,peer,validator,auditor,intermediateca",
 			"hf.Registrar.DelegateRoles": "client,user,validator,auditor",
 			"hf.Revoker":                 "true",
 		},
@@ -267,169 +251,174 @@ func (s *Server) initConfig() (err error) {
 	if cfg.Port == 0 {
 		cfg.Port = DefaultServerPort
 	}
-	if cfg.CA.Certfile == "" {
-		cfg.CA.Certfile = "ca-cert.pem"
-	}
-	if cfg.CA.Keyfile == "" {
-		cfg.CA.Keyfile = "ca-key.pem"
-	}
-	if cfg.CSR.CN == "" {
-		cfg.CSR.CN = "fabric-ca-server"
-	}
 	// Set log level if debug is true
 	if cfg.Debug {
 		log.Level = log.LevelDebug
 	}
-	return nil
-}
-
-// Initialize the database for the server
-func (s *Server) initDB() error {
-	db := &s.Config.DB
-
-	log.Debugf("Initializing '%s' data base at '%s'", db.Type, db.Datasource)
-
-	var err error
-	var exists bool
-
-	if db.Type == "" {
-		db.Type = "sqlite3"
-	}
-	if db.Datasource == "" {
-		var ds string
-		ds, err = util.MakeFileAbs("fabric-ca-server.db", s.HomeDir)
-		if err != nil {
-			return err
-		}
-		db.Datasource = ds
-	}
-	switch db.Type {
-	case "sqlite3":
-		s.db, exists, err = dbutil.NewUserRegistrySQLLite3(db.Datasource)
-		if err != nil {
-			return err
-		}
-	case "postgres":
-		s.db, exists, err = dbutil.NewUserRegistryPostgres(db.Datasource, &db.TLS)
-		if err != nil {
-			return err
+	if cfg.TLS.Enabled {
+		if cfg.TLS.CertFile == "" {
+			cfg.TLS.CertFile = DefaultTLSCertFile
 		}
-	case "mysql":
-		s.db, exists, err = dbutil.NewUserRegistryMySQL(db.Datasource, &db.TLS)
-		if err != nil {
-			return err
+		if cfg.TLS.KeyFile == "" {
+			cfg.TLS.KeyFile = DefaultTLSKeyFile
 		}
-	default:
-		return fmt.Errorf("Invalid db.type in config file: '%s'; must be 'sqlite3', 'postgres', or 'mysql'", db.Type)
 	}
-
-	// Set the certificate DB accessor
-	s.certDBAccessor = NewCertDBAccessor(s.db)
-	MyCertDBAccessor = s.certDBAccessor
-
-	// Initialize the user registry.
-	// If LDAP is not configured, the fabric-ca server functions as a user
-	// registry based on the database.
-	err = s.initUserRegistry()
+	tlsFiles := []*string{&cfg.TLS.CertFile, &cfg.TLS.KeyFile}
+	for i := range cfg.TLS.ClientAuth.CertFiles {
+		tlsFiles = append(tlsFiles, &cfg.TLS.ClientAuth.CertFiles[i])
+	}
+	err = makeFileNamesAbsolute(tlsFiles, s.HomeDir)
 	if err != nil {
 		return err
 	}
+	// The default CA's home directory and config are the server's
+	s.CA.HomeDir = s.HomeDir
+	s.CA.Config = &cfg.CAConfig
+	return s.CA.initConfig()
+}
 
-	// If the DB doesn't exist, bootstrap it
-	if !exists {
-		err = s.loadUsersTable()
+// Load the config of each additional CA from its CA config file
+func (s *Server) loadCAs() error {
+	s.caMap = map[string]*CA{}
+	for _, file := range s.Config.CAfiles {
+		file, err := util.MakeFileAbs(file, s.HomeDir)
 		if err != nil {
 			return err
 		}
-		err = s.loadAffiliationsTable()
+		ca, err := newCAFromFile(file)
 		if err != nil {
 			return err
 		}
+		name := ca.Config.CA.Name
+		if _, found := s.caMap[name]; found || name == s.CA.Config.CA.Name {
+			return fmt.Errorf("CA name '%s' in CA config file '%s' is already in use", name, file)
+		}
+		s.caMap[name] = ca
+		log.Infof("Loaded CA '%s' from CA config file %s", name, file)
 	}
-	log.Infof("Initialized %s data base at %s", db.Type, db.Datasource)
 	return nil
 }
 
-// Initialize the user registry interface
-func (s *Server) initUserRegistry() error {
-	log.Debug("Initializing user registry")
-	var err error
-	ldapCfg := &s.Config.LDAP
-
-	if ldapCfg.Enabled {
-		// Use LDAP for the user registry
-		s.registry, err = ldap.NewClient(ldapCfg)
-		UserRegistry = s.registry
-		log.Debugf("Initialized LDAP user registry; err=%s", err)
-		return err
+// GetCA returns the CA with the given name.  The default CA is returned
+// for its own name or for the empty string.
+func (s *Server) GetCA(name string) (*CA, error) {
+	if name == "" || name == s.CA.Config.CA.Name {
+		return &s.CA, nil
 	}
-
-	// Use the DB for the user registry
-	dbAccessor := new(Accessor)
-	dbAccessor.SetDB(s.db)
-	s.registry = dbAccessor
-	UserRegistry = s.registry
-	log.Debug("Initialized DB user registry")
-	return nil
+	ca, found := s.caMap[name]
+	if !found {
+		return nil, fmt.Errorf("CA '%s' does not exist", name)
+	}
+	return ca, nil
 }
 
-// Initialize the enrollment signer
-func (s *Server) initEnrollmentSigner() (err error) {
-
-	c := s.Config
-
-	// If there is a config, use its signing policy. Otherwise create a default policy.
-	var policy *config.Signing
-	if c.Signing != nil {
-		policy = c.Signing
-	} else {
-		policy = &config.Signing{
-			Profiles: map[string]*config.SigningProfile{},
-			Default:  config.DefaultConfig(),
+// getCAForRequest returns the CA selected by the request's caname header
+// or, if the header isn't set, by the caname field of the request's body
+func (s *Server) getCAForRequest(r *http.Request) (*CA, error) {
+	name := r.Header.Get(caNameHdrName)
+	if name == "" && r.Body != nil {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, newServerError(ErrCodeBadRequest, "Failure reading request body: %s", err)
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		// A malformed body is reported by the endpoint's handler
+		var req struct {
+			CAName string `json:"caname"`
 		}
+		json.Unmarshal(body, &req)
+		name = req.CAName
+	}
+	ca, err := s.GetCA(name)
+	if err != nil {
+		return nil, newServerError(ErrCodeCANotFound, "%s", err)
 	}
+	return ca, nil
+}
 
-	// Make sure the policy reflects the new remote
-	if c.Remote != "" {
-		err = policy.OverrideRemotes(c.Remote)
-		if err != nil {
-			return fmt.Errorf("Failed initializing enrollment signer: %s", err)
-		}
+// getCAs returns the default CA followed by the additional CAs
+func (s *Server) getCAs() []*CA {
+	cas := []*CA{&s.CA}
+	for _, ca := range s.caMap {
+		cas = append(cas, ca)
 	}
+	return cas
+}
 
-	// Get CFSSL's universal root and signer
-	root := universal.Root{
-		Config: map[string]string{
-			"cert-file": c.CA.Certfile,
-			"key-file":  c.CA.Keyfile,
-		},
-		ForceRemote: c.Remote != "",
+// addIdentityTypeProfiles returns a copy of the signing policy with a
+// profile for each identity type, which is the default profile with the
+// usages, expiry and CA constraint of the identity type
+func addIdentityTypeProfiles(policy *config.Signing, types map[string]ServerConfigIdentityType) (*config.Signing, error) {
+	if len(types) == 0 {
+		return policy, nil
 	}
-	s.enrollSigner, err = universal.NewSigner(root, policy)
-	EnrollSigner = s.enrollSigner
-	if err != nil {
-		return err
+	def := policy.Default
+	if def == nil {
+		def = config.DefaultConfig()
+	}
+	profiles := map[string]*config.SigningProfile{}
+	for name, profile := range policy.Profiles {
+		profiles[name] = profile
+	}
+	for name, idType := range types {
+		if _, found := profiles[name]; found {
+			return nil, fmt.Errorf("Signing profile '%s' has the same name as an identity type", name)
+		}
+		for _, pname := range idType.Profiles {
+			if _, found := policy.Profiles[pname]; !found {
+				return nil, fmt.Errorf("Identity type '%s' refers to unknown signing profile '%s'", name, pname)
+			}
+		}
+		profile := *def
+		if len(idType.Usage) > 0 || len(idType.ExtUsage) > 0 {
+			profile.Usage = append(append([]string{}, idType.Usage...), idType.ExtUsage...)
+		}
+		if idType.Expiry > 0 {
+			profile.Expiry = idType.Expiry
+			profile.ExpiryString = idType.Expiry.String()
+		}
+		profile.CAConstraint = idType.CAConstraint
+		profiles[name] = &profile
 	}
-	s.enrollSigner.SetDBAccessor(s.certDBAccessor)
+	return &config.Signing{Profiles: profiles, Default: policy.Default}, nil
+}
 
-	// Successful enrollment
-	return nil
+// allowAttrsExtension returns a copy of the signing policy in which every
+// profile allows the extension containing the attributes of an identity
+func allowAttrsExtension(policy *config.Signing) *config.Signing {
+	allow := func(profile *config.SigningProfile) *config.SigningProfile {
+		if profile == nil {
+			return nil
+		}
+		p := *profile
+		p.ExtensionWhitelist = map[string]bool{attrmgr.AttrOIDString: true}
+		for oid, allowed := range profile.ExtensionWhitelist {
+			p.ExtensionWhitelist[oid] = allowed
+		}
+		return &p
+	}
+	profiles := map[string]*config.SigningProfile{}
+	for name, profile := range policy.Profiles {
+		profiles[name] = allow(profile)
+	}
+	return &config.Signing{Profiles: profiles, Default: allow(policy.Default)}
 }
 
 // Register all endpoint handlers
 func (s *Server) registerHandlers() {
 	s.mux = http.NewServeMux()
-	s.registerHandlerLog("register", NewRegisterHandler)
-	s.registerHandlerLog("enroll", NewEnrollHandler)
-	s.registerHandlerLog("reenroll", NewReenrollHandler)
-	s.registerHandlerLog("revoke", NewRevokeHandler)
-	s.registerHandlerLog("tcert", NewTCertHandler)
+	s.registerHandlerLog("register", newRegisterHandler)
+	s.registerHandlerLog("enroll", newEnrollHandler)
+	s.registerHandlerLog("reenroll", newReenrollHandler)
+	s.registerHandlerLog("revoke", newRevokeHandler)
+	s.registerHandlerLog("tcert", newTCertHandler)
+	s.registerHandlerLog("cainfo", newCAInfoHandler)
 }
 
 // Register an endpoint handler and log success or error
 func (s *Server) registerHandlerLog(
 	path string,
-	getHandler func() (http.Handler, error)) {
+	getHandler func(getCA caGetter) (http.Handler, error)) {
 	err := s.registerHandler(path, getHandler)
 	if err != nil {
 		log.Warningf("Endpoint '%s' is disabled: %s", path, err)
@@ -441,22 +430,44 @@ func (s *Server) registerHandlerLog(
 // Register an endpoint handler and return an error if unsuccessful
 func (s *Server) registerHandler(
 	path string,
-	getHandler func() (http.Handler, error)) (err error) {
+	getHandler func(getCA caGetter) (http.Handler, error)) (err error) {
 
 	var handler http.Handler
 
-	handler, err = getHandler()
+	handler, err = getHandler(s.getCAForRequest)
 	if err != nil {
 		return fmt.Errorf("Endpoint '%s' is disabled: %s", path, err)
 	}
-	path, handler, err = NewAuthWrapper(path, handler, err)
+	matchTLSCert := s.Config.TLS.Enabled && s.Config.TLS.ClientAuth.MatchToken
+	path, handler, err = newAuthWrapper(path, handler, s.getCAForRequest, matchTLSCert, err)
 	if err != nil {
 		return fmt.Errorf("Endpoint '%s' has been disabled: %s", path, err)
 	}
-	s.mux.Handle(path, handler)
+	s.mux.Handle(path, s.withHandlerTracking(s.withConfigReadLock(handler)))
 	return nil
 }
 
+// withHandlerTracking returns a handler which Stop waits for, so that the
+// CAs are not closed while a request is still using them
+func (s *Server) withHandlerTracking(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.handlers.Add(1)
+		defer s.handlers.Done()
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// withConfigReadLock returns a handler which serves requests holding the
+// read lock of the config, so that a reload does not change the config
+// while a request is served
+func (s *Server) withConfigReadLock(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.configMutex.RLock()
+		defer s.configMutex.RUnlock()
+		handler.ServeHTTP(w, r)
+	})
+}
+
 // Starting listening and serving
 func (s *Server) listenAndServe() (err error) {
 
@@ -475,12 +486,23 @@ func (s *Server) listenAndServe() (err error) {
 
 	if c.TLS.Enabled {
 		log.Debug("TLS is enabled")
-		var cer tls.Certificate
-		cer, err = tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile)
+		var cer *tls.Certificate
+		cer, err = s.loadTLSCert(c.TLS.CertFile, c.TLS.KeyFile)
+		if err != nil {
+			return err
+		}
+		s.tlsCert.Store(cer)
+		// The certificate is looked up for each handshake, so that a reload
+		// of the config can replace it
+		config := &tls.Config{GetCertificate: s.getTLSCert}
+		err = libtls.SetClientAuth(config, &c.TLS.ClientAuth)
+		if err != nil {
+			return err
+		}
+		err = libtls.SetProtocol(config, &c.TLS.ProtocolConfig)
 		if err != nil {
 			return err
 		}
-		config := &tls.Config{Certificates: []tls.Certificate{cer}}
 		listener, err = tls.Listen("tcp", addr, config)
 		if err != nil {
 			return fmt.Errorf("TLS listen failed: %s", err)
@@ -494,17 +516,31 @@ func (s *Server) listenAndServe() (err error) {
 		log.Infof("Listening at http://%s", addr)
 	}
 	s.listener = listener
+	httpServer := &http.Server{Handler: s.mux}
+	s.httpServer = httpServer
+	s.stopped = make(chan struct{})
 
 	// Start serving requests, either blocking or non-blocking
 	if s.BlockingStart {
-		return s.serve()
+		return s.serve(httpServer, listener, s.stopped)
 	}
-	go s.serve()
+	go s.serve(httpServer, listener, s.stopped)
 	return nil
 }
 
-func (s *Server) serve() error {
-	s.serveError = http.Serve(s.listener, s.mux)
+// getTLSCert returns the server's current TLS certificate
+func (s *Server) getTLSCert(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.tlsCert.Load().(*tls.Certificate), nil
+}
+
+func (s *Server) serve(httpServer *http.Server, listener net.Listener, stopped <-chan struct{}) error {
+	err := httpServer.Serve(listener)
+	if err == http.ErrServerClosed {
+		// The server is being stopped, so wait for Stop to finish
+		<-stopped
+		return nil
+	}
+	s.serveError = err
 	log.Errorf("Server has stopped serving: %s", s.serveError)
 	if s.listener != nil {
 		s.listener.Close()
@@ -513,151 +549,10 @@ func (s *Server) serve() error {
 	return s.serveError
 }
 
-// loadUsersTable adds the configured users to the table if not already found
-func (s *Server) loadUsersTable() error {
-	log.Debug("Loading users table")
-	registry := &s.Config.Registry
-	for _, id := range registry.Identities {
-		log.Debugf("Loading identity '%s'", id.ID)
-		err := s.addIdentity(&id, false)
-		if err != nil {
-			return err
-		}
-	}
-	log.Debug("Successfully loaded users table")
-	return nil
-}
-
-// loadAffiliationsTable adds the configured affiliations to the table
-func (s *Server) loadAffiliationsTable() error {
-	log.Debug("Loading affiliations table")
-	err := s.loadAffiliationsTableR(s.Config.Affiliations, "")
-	if err == nil {
-		log.Debug("Successfully loaded affiliations table")
-	}
-	return err
-}
-
-// Recursive function to load the affiliations table hierarchy
-func (s *Server) loadAffiliationsTableR(val interface{}, parentPath string) (err error) {
-	var path string
-	if val == nil {
-		return nil
-	}
-	switch val.(type) {
-	case string:
-		path = affiliationPath(val.(string), parentPath)
-		err = s.addAffiliation(path, parentPath)
-		if err != nil {
-			return err
-		}
-	case []string:
-		for _, ele := range val.([]string) {
-			err = s.loadAffiliationsTableR(ele, parentPath)
-			if err != nil {
-				return err
-			}
-		}
-	case []interface{}:
-		for _, ele := range val.([]interface{}) {
-			err = s.loadAffiliationsTableR(ele, parentPath)
-			if err != nil {
-				return err
-			}
-		}
-	default:
-		for name, ele := range val.(map[string]interface{}) {
-			path = affiliationPath(name, parentPath)
-			err = s.addAffiliation(path, parentPath)
-			if err != nil {
-				return err
-			}
-			err = s.loadAffiliationsTableR(ele, path)
-			if err != nil {
-				return err
-			}
-		}
-	}
-	return nil
-}
-
-// Add an identity to the registry
-func (s *Server) addIdentity(id *ServerConfigIdentity, errIfFound bool) error {
-	user, _ := s.registry.GetUser(id.ID, nil)
-	if user != nil {
-		if errIfFound {
-			return fmt.Errorf("Identity '%s' is already registered", id.ID)
-		}
-		log.Debugf("Loaded identity: %+v", id)
-		return nil
-	}
-	maxEnrollments, err := s.getMaxEnrollments(id.MaxEnrollments)
-	if err != nil {
-		return err
-	}
-	rec := spi.UserInfo{
-		Name:           id.ID,
-		Pass:           id.Pass,
-		Type:           id.Type,
-		Group:          id.Affiliation,
-		Attributes:     s.convertAttrs(id.Attributes),
-		MaxEnrollments: maxEnrollments,
-	}
-	err = s.registry.InsertUser(rec)
-	if err != nil {
-		return fmt.Errorf("Failed to insert user '%s': %s", id.ID, err)
-	}
-	log.Debugf("Registered identity: %+v", id)
-	return nil
-}
-
-func (s *Server) addAffiliation(path, parentPath string) error {
-	log.Debugf("Adding affiliation %s", path)
-	return s.registry.InsertGroup(path, parentPath)
-}
-
-func (s *Server) convertAttrs(inAttrs map[string]string) []api.Attribute {
-	outAttrs := make([]api.Attribute, 0)
-	for name, value := range inAttrs {
-		outAttrs = append(outAttrs, api.Attribute{
-			Name:  name,
-			Value: value,
-		})
-	}
-	return outAttrs
-}
-
-// Get max enrollments relative to the configured max
-func (s *Server) getMaxEnrollments(requestedMax int) (int, error) {
-	configuredMax := s.Config.Registry.MaxEnrollments
-	if requestedMax < 0 {
-		return configuredMax, nil
-	}
-	if configuredMax == 0 {
-		// no limit, so grant any request
-		return requestedMax, nil
-	}
-	if requestedMax == 0 && configuredMax != 0 {
-		return 0, fmt.Errorf("Infinite enrollments is not permitted; max is %d",
-			configuredMax)
-	}
-	if requestedMax > configuredMax {
-		return 0, fmt.Errorf("Max enrollments of %d is not permitted; max is %d",
-			requestedMax, configuredMax)
-	}
-	return requestedMax, nil
-}
-
-// Make all file names in the config absolute
-func (s *Server) makeFileNamesAbsolute() error {
-	fields := []*string{
-		&s.Config.CA.Certfile,
-		&s.Config.CA.Keyfile,
-		&s.Config.TLS.CertFile,
-		&s.Config.TLS.KeyFile,
-	}
+// Make the file names referenced by fields absolute relative to homeDir
+func makeFileNamesAbsolute(fields []*string, homeDir string) error {
 	for _, namePtr := range fields {
-		abs, err := util.MakeFileAbs(*namePtr, s.HomeDir)
+		abs, err := util.MakeFileAbs(*namePtr, homeDir)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,143 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cfssl/log"
+	"github.com/hyperledger/fabric-ca/util"
+)
+
+// Prune archives the certificates of each CA which have been expired for
+// longer than the configured retention period.  It is used by the "db prune"
+// command and initializes only as much of the server as is needed to do so.
+// Returns the number of certificates which were archived.
+func (s *Server) Prune() (int, error) {
+	err := s.initConfig()
+	if err != nil {
+		return 0, err
+	}
+	err = s.loadCAs()
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, ca := range s.getCAs() {
+		err = ca.initDB()
+		if err != nil {
+			return total, err
+		}
+		count, err := ca.archiveExpiredCertificates()
+		if err != nil {
+			return total, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// Archive the certificates which have been expired for longer than the
+// configured retention period.  Revoked certificates are kept for at least
+// the revoked retention period so that they are available for the last CRL.
+func (ca *CA) archiveExpiredCertificates() (int, error) {
+	cfg := &ca.Config.Archive
+	if cfg.Retention < 0 || cfg.RevokedRetention < 0 {
+		return 0, fmt.Errorf("Invalid archive retention: retention=%s, revokedRetention=%s",
+			cfg.Retention, cfg.RevokedRetention)
+	}
+	now := time.Now()
+	expiredBefore := now.Add(-cfg.Retention)
+	revokedBefore := now.Add(-cfg.RevokedRetention)
+	if revokedBefore.After(expiredBefore) {
+		revokedBefore = expiredBefore
+	}
+
+	count, err := ca.certDBAccessor.ArchiveExpiredCertificates(expiredBefore, revokedBefore, cfg.File)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to archive expired certificates: %s", err)
+	}
+	if count > 0 {
+		log.Infof("Archived %d expired certificates", count)
+	} else {
+		log.Debug("No expired certificates to archive")
+	}
+	return count, nil
+}
+
+// Start archiving the expired certificates of each CA if configured
+func (s *Server) startArchiver() {
+	stop := make(chan struct{})
+	s.archiverStop = stop
+	for _, ca := range s.getCAs() {
+		ca.startArchiver(stop, &s.background)
+	}
+}
+
+// Start archiving the CA's expired certificates in the background, until
+// stop is closed, if configured.  wg is done when archiving has stopped.
+func (ca *CA) startArchiver(stop chan struct{}, wg *sync.WaitGroup) {
+	interval := ca.Config.Archive.Interval
+	if interval <= 0 {
+		log.Debugf("Background archiving of expired certificates is disabled for CA '%s'", ca.Config.CA.Name)
+		return
+	}
+	log.Infof("Archiving expired certificates of CA '%s' every %s", ca.Config.CA.Name, interval)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_, err := ca.archiveExpiredCertificates()
+				if err != nil {
+					log.Errorf("Background archiving failed: %s", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop archiving expired certificates in the background.  An archiving run
+// which is in progress completes; wait for s.background to wait for it.
+func (s *Server) stopArchiver() {
+	if s.archiverStop != nil {
+		close(s.archiverStop)
+		s.archiverStop = nil
+	}
+}
+
+// Set the defaults for the archive config and make its file name absolute
+func (ca *CA) initArchiveConfig() (err error) {
+	cfg := &ca.Config.Archive
+	if cfg.Retention == 0 {
+		cfg.Retention = DefaultArchiveRetention
+	}
+	if cfg.RevokedRetention == 0 {
+		cfg.RevokedRetention = DefaultArchiveRevokedRetention
+	}
+	if cfg.File != "" {
+		cfg.File, err = util.MakeFileAbs(cfg.File, ca.HomeDir)
+	}
+	return err
+}
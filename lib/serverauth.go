@@ -18,12 +18,9 @@ package lib
 
 import (
 	"bytes"
-	"errors"
 	"io/ioutil"
 	"net/http"
 
-	"github.com/cloudflare/cfssl/api"
-	cerr "github.com/cloudflare/cfssl/errors"
 	"github.com/cloudflare/cfssl/log"
 	"github.com/cloudflare/cfssl/revoke"
 	"github.com/hyperledger/fabric-ca/util"
@@ -31,44 +28,59 @@ import (
 
 const (
 	enrollmentIDHdrName = "__eid__"
+	// The header which selects the CA to which a request is directed
+	caNameHdrName = "caname"
 )
 
+// caGetter returns the CA to which a request is directed
+type caGetter func(r *http.Request) (*CA, error)
+
 // AuthHandler
 type fcaAuthHandler struct {
 	basic bool
 	token bool
-	next  http.Handler
+	// Whether the TLS client certificate must belong to the token's identity
+	matchTLSCert bool
+	getCA        caGetter
+	next         http.Handler
 }
 
-var authError = cerr.NewBadRequest(errors.New("authorization failure"))
-
-// NewAuthWrapper is auth wrapper constructor.
-// Only the "enroll" URI uses basic auth for the enrollment secret, while all
-// others require a token which proves ownership of an ecert.
-func NewAuthWrapper(path string, handler http.Handler, err error) (string, http.Handler, error) {
+// newAuthWrapper is auth wrapper constructor.
+// Only the "enroll" URI uses basic auth for the enrollment secret, and the
+// "cainfo" URI is not authenticated, while all others require a token which
+// proves ownership of an ecert.
+// Requests are authenticated by the CA to which they are directed.
+// If matchTLSCert is true, the TLS client certificate of a request which is
+// authenticated by a token must belong to the token's identity.
+func newAuthWrapper(path string, handler http.Handler, getCA caGetter, matchTLSCert bool, err error) (string, http.Handler, error) {
+	if path == "cainfo" {
+		return wrappedPath(path), handler, err
+	}
 	if path == "enroll" {
-		handler, err = newBasicAuthHandler(handler, err)
+		handler, err = newBasicAuthHandler(handler, getCA, err)
 		return wrappedPath(path), handler, err
 	}
-	handler, err = newTokenAuthHandler(handler, err)
+	handler, err = newTokenAuthHandler(handler, getCA, matchTLSCert, err)
 	return wrappedPath(path), handler, err
 }
 
-func newBasicAuthHandler(handler http.Handler, errArg error) (h http.Handler, err error) {
-	return newAuthHandler(true, false, handler, errArg)
+func newBasicAuthHandler(handler http.Handler, getCA caGetter, errArg error) (h http.Handler, err error) {
+	return newAuthHandler(true, false, false, handler, getCA, errArg)
 }
 
-func newTokenAuthHandler(handler http.Handler, errArg error) (h http.Handler, err error) {
-	return newAuthHandler(false, true, handler, errArg)
+func newTokenAuthHandler(handler http.Handler, getCA caGetter, matchTLSCert bool, errArg error) (h http.Handler, err error) {
+	return newAuthHandler(false, true, matchTLSCert, handler, getCA, errArg)
 }
 
-func newAuthHandler(basic, token bool, handler http.Handler, errArg error) (h http.Handler, err error) {
+func newAuthHandler(basic, token, matchTLSCert bool, handler http.Handler, getCA caGetter, errArg error) (h http.Handler, err error) {
 	if errArg != nil {
 		return nil, errArg
 	}
 	ah := new(fcaAuthHandler)
 	ah.basic = basic
 	ah.token = token
+	ah.matchTLSCert = matchTLSCert
+	ah.getCA = getCA
 	ah.next = handler
 	return ah, nil
 }
@@ -76,7 +88,7 @@ func newAuthHandler(basic, token bool, handler http.Handler, errArg error) (h ht
 func (ah *fcaAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	err := ah.serveHTTP(w, r)
 	if err != nil {
-		api.HandleError(w, err)
+		handleError(w, err)
 	} else {
 		ah.next.ServeHTTP(w, r)
 	}
@@ -90,21 +102,33 @@ func (ah *fcaAuthHandler) serveHTTP(w http.ResponseWriter, r *http.Request) erro
 		log.Debug("No authorization header")
 		return errNoAuthHdr
 	}
+	ca, err := ah.getCA(r)
+	if err != nil {
+		log.Debugf("Failed to get CA for request: %s", err)
+		return err
+	}
+	// Let the handler find the CA without parsing the body again
+	r.Header.Set(caNameHdrName, ca.Config.CA.Name)
 	user, pwd, ok := r.BasicAuth()
 	if ok {
 		if !ah.basic {
 			log.Debugf("Basic auth is not allowed; found %s", authHdr)
 			return errBasicAuthNotAllowed
 		}
-		u, err := UserRegistry.GetUser(user, nil)
+		u, err := ca.registry.GetUser(user, nil)
 		if err != nil {
 			log.Debugf("Failed to get user '%s': %s", user, err)
-			return authError
+			return errAuthFailed
 		}
 		err = u.Login(pwd)
 		if err != nil {
 			log.Debugf("Failed to login '%s': %s", user, err)
-			return authError
+			// Errors of an identity whose password was correct, such as
+			// having reached its maximum enrollments, are reported
+			if se, ok := err.(*ServerError); ok {
+				return se
+			}
+			return errAuthFailed
 		}
 		log.Debug("User/Pass was correct")
 		r.Header.Set(enrollmentIDHdrName, user)
@@ -116,26 +140,42 @@ func (ah *fcaAuthHandler) serveHTTP(w http.ResponseWriter, r *http.Request) erro
 		body, err := ioutil.ReadAll(r.Body)
 		if err != nil {
 			log.Debugf("Failed to read body: %s", err)
-			return authError
+			return errAuthFailed
 		}
 		r.Body = ioutil.NopCloser(bytes.NewReader(body))
 		// verify token
-		cert, err2 := util.VerifyToken(MyCSP, authHdr, body)
+		cert, err2 := util.VerifyToken(ca.csp, authHdr, body)
 		if err2 != nil {
 			log.Debugf("Failed to verify token: %s", err2)
-			return authError
+			return errAuthFailed
 		}
 		id := util.GetEnrollmentIDFromX509Certificate(cert)
+		err = ca.checkIssuer(cert)
+		if err != nil {
+			log.Debugf("Failed to authenticate '%s': %s", id, err)
+			return errAuthFailed
+		}
 		log.Debugf("Checking for revocation/expiration of certificate owned by '%s'", id)
 		// Check for certificate revocation and expiration
 		revokedOrExpired, checked := revoke.VerifyCertificate(cert)
 		if revokedOrExpired {
 			log.Debugf("Certificate was either revoked or has expired owned by '%s'", id)
-			return authError
+			return errAuthFailed
 		}
 		if !checked {
 			log.Debug("A failure occurred while checking for revocation and expiration")
-			return authError
+			return errAuthFailed
+		}
+		if ah.matchTLSCert {
+			if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+				log.Debugf("No TLS client certificate was presented by '%s'", id)
+				return errAuthFailed
+			}
+			tlsID := util.GetEnrollmentIDFromX509Certificate(r.TLS.PeerCertificates[0])
+			if tlsID != id {
+				log.Debugf("TLS client certificate of '%s' does not belong to '%s'", tlsID, id)
+				return errAuthFailed
+			}
 		}
 		log.Debugf("Successful authentication of '%s'", id)
 		r.Header.Set(enrollmentIDHdrName, util.GetEnrollmentIDFromX509Certificate(cert))
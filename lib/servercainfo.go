@@ -0,0 +1,56 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"net/http"
+
+	cfsslapi "github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/log"
+
+	"github.com/hyperledger/fabric-ca/api"
+	"github.com/hyperledger/fabric-ca/lib/metadata"
+)
+
+// newCAInfoHandler is constructor for cainfo handler
+func newCAInfoHandler(getCA caGetter) (h http.Handler, err error) {
+	return newHTTPHandler(&caInfoHandler{getCA: getCA}, "GET", "POST"), nil
+}
+
+// caInfoHandler for cainfo requests, which are not authenticated so that
+// clients can get the CA chain before they trust the server
+type caInfoHandler struct {
+	// Returns the CA whose information is requested
+	getCA caGetter
+}
+
+// Handle a cainfo request
+func (h *caInfoHandler) Handle(w http.ResponseWriter, r *http.Request) error {
+	log.Debug("CA info request received")
+	ca, err := h.getCA(r)
+	if err != nil {
+		return err
+	}
+	resp := &api.GetCAInfoResponseNet{
+		GetCAInfoResponse: api.GetCAInfoResponse{
+			CAName:  ca.Config.CA.Name,
+			CAChain: ca.chain,
+			Version: metadata.GetVersion(),
+		},
+	}
+	return cfsslapi.SendResponse(w, resp)
+}
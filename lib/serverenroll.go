@@ -17,39 +17,59 @@ limitations under the License.
 package lib
 
 import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 
 	cfsslapi "github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/csr"
 	"github.com/cloudflare/cfssl/log"
 	"github.com/cloudflare/cfssl/signer"
+	"github.com/hyperledger/fabric-ca/api"
+	"github.com/hyperledger/fabric-ca/lib/attrmgr"
+	"github.com/hyperledger/fabric-ca/lib/spi"
 	"github.com/hyperledger/fabric-ca/util"
 )
 
-// NewEnrollHandler is the constructor for the enroll handler
-func NewEnrollHandler() (h http.Handler, err error) {
-	return newSignHandler("enroll")
+const (
+	// Attribute listing the hosts (SANs) an identity may request in its
+	// certificate; "*" allows any host and "*.domain" allows any subdomain.
+	// An identity without this attribute may only have its enrollment ID.
+	allowedHostsAttr = "hf.AllowedHosts"
+	// OU of the subject of an identity with neither a type nor an affiliation
+	defaultSubjectOU = "client"
+	// Attribute listing the signing profiles an identity may request
+	allowedProfilesAttr = "hf.AllowedProfiles"
+)
+
+// newEnrollHandler is the constructor for the enroll handler
+func newEnrollHandler(getCA caGetter) (h http.Handler, err error) {
+	return newSignHandler("enroll", getCA)
 }
 
-// NewReenrollHandler is the constructor for the reenroll handler
-func NewReenrollHandler() (h http.Handler, err error) {
-	return newSignHandler("reenroll")
+// newReenrollHandler is the constructor for the reenroll handler
+func newReenrollHandler(getCA caGetter) (h http.Handler, err error) {
+	return newSignHandler("reenroll", getCA)
 }
 
 // signHandler for enroll or reenroll requests
 type signHandler struct {
 	// "enroll" or "reenroll"
 	endpoint string
+	// Returns the CA which signs the request
+	getCA caGetter
 }
 
-// newEnrollHandler is the constructor for an enroll or reenroll handler
-func newSignHandler(endpoint string) (h http.Handler, err error) {
-	// NewHandler is constructor for register handler
-	return &cfsslapi.HTTPHandler{
-		Handler: &signHandler{endpoint: endpoint},
-		Methods: []string{"POST"},
-	}, nil
+// newSignHandler is the constructor for an enroll or reenroll handler
+func newSignHandler(endpoint string, getCA caGetter) (h http.Handler, err error) {
+	return newHTTPHandler(&signHandler{endpoint: endpoint, getCA: getCA}, "POST"), nil
 }
 
 // Handle an enroll or reenroll request.
@@ -59,26 +79,295 @@ func (sh *signHandler) Handle(w http.ResponseWriter, r *http.Request) error {
 
 	log.Debugf("Received request for endpoint %s", sh.endpoint)
 
+	// Get the CA to which the request is directed
+	ca, err := sh.getCA(r)
+	if err != nil {
+		return err
+	}
+
 	// Read the request's body
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return err
+		return newServerError(ErrCodeBadRequest, "Failure reading request body: %s", err)
 	}
 	r.Body.Close()
 
-	// Unmarshall the request body
-	var req signer.SignRequest
+	// Unmarshall the request body; enroll and reenroll requests are the same
+	var req api.EnrollmentRequestNet
 	err = util.Unmarshal(body, &req, sh.endpoint)
 	if err != nil {
+		return newServerError(ErrCodeBadRequest, "%s", err)
+	}
+
+	// Make sure that the certificate is issued to the authenticated identity
+	// and contains the requested attributes
+	id := r.Header.Get(enrollmentIDHdrName)
+	err = ca.authorizeSignRequest(id, &req)
+	if err != nil {
+		log.Errorf("Unauthorized request for endpoint %s: %s", sh.endpoint, err)
 		return err
 	}
 
-	cert, err := EnrollSigner.Sign(req)
+	cert, err := ca.enrollSigner.Sign(req.SignRequest)
 	if err != nil {
 		err = fmt.Errorf("Failed signing for endpoint %s: %s", sh.endpoint, err)
 		log.Error(err.Error())
 		return err
 	}
 
+	// The CA chain is returned by the cainfo endpoint, not with the certificate
 	return cfsslapi.SendResponse(w, cert)
 }
+
+// authorizeSignRequest forces the subject of the certificate to be issued
+// to identify the caller, makes sure that the caller is entitled to the
+// requested hosts and profile, and adds the requested attributes.
+func (ca *CA) authorizeSignRequest(id string, req *api.EnrollmentRequestNet) error {
+	if id == "" {
+		return newServerError(ErrCodeAuthFailed, "No enrollment ID was found for the request")
+	}
+	// Get the attributes needed to authorize the request, since a registry
+	// such as LDAP only returns the attributes which are asked for
+	attrNames := []string{allowedHostsAttr, allowedProfilesAttr}
+	for _, ar := range req.AttrReqs {
+		attrNames = append(attrNames, ar.Name)
+	}
+	user, err := ca.registry.GetUser(id, attrNames)
+	if err != nil {
+		return newServerError(ErrCodeIdentityNotFound, "Failed to get identity '%s': %s", id, err)
+	}
+
+	// The subject is built entirely by the server: the CN is always the
+	// enrollment ID, the OUs are always the identity's type followed by its
+	// affiliation path, and the other fields are empty.  The signing policy
+	// does not copy the subject from the CSR, so any subject in the CSR or in
+	// the request is ignored.
+	req.Subject = &signer.Subject{
+		CN:    id,
+		Names: getSubjectNames(user),
+	}
+
+	// Check the requested hosts, which come from the request if present or
+	// from the CSR otherwise, against those the identity is entitled to.  An
+	// identity without the hf.AllowedHosts attribute may only request its
+	// enrollment ID as a host.  The checked hosts always replace those of
+	// the CSR.
+	hosts, err := getRequestedHosts(&req.SignRequest)
+	if err != nil {
+		return newServerError(ErrCodeBadRequest, "%s", err)
+	}
+	allowedHosts := user.GetAttribute(allowedHostsAttr)
+	for _, host := range hosts {
+		if allowedHosts == "" {
+			if !strings.EqualFold(host, id) {
+				return newServerError(ErrCodeNotAuthorized, "Identity '%s' may not request host '%s' since it has no %s attribute",
+					id, host, allowedHostsAttr)
+			}
+			continue
+		}
+		if !hostAllowed(host, splitAttrValue(allowedHosts)) {
+			return newServerError(ErrCodeNotAuthorized, "Identity '%s' may not request host '%s'", id, host)
+		}
+	}
+	req.Hosts = append([]string{}, hosts...)
+
+	// Select or check the requested profile
+	if !ca.selectProfile(user, &req.SignRequest) {
+		return newServerError(ErrCodeNotAuthorized, "Identity '%s' may not request profile '%s'", id, req.Profile)
+	}
+
+	return addAttrsExtension(user, req)
+}
+
+// addAttrsExtension adds the extension containing the requested attributes
+// of the identity to the request.  The extension may only be added by the
+// server, so a request which already contains it is rejected.
+func addAttrsExtension(user spi.User, req *api.EnrollmentRequestNet) error {
+	for _, ext := range req.Extensions {
+		if asn1.ObjectIdentifier(ext.ID).Equal(attrmgr.AttrOID) {
+			return newServerError(ErrCodeBadRequest, "The attribute extension may not be included in the request")
+		}
+	}
+	if len(req.AttrReqs) == 0 {
+		return nil
+	}
+	attrReqs := make([]attrmgr.AttributeRequest, len(req.AttrReqs))
+	for i, ar := range req.AttrReqs {
+		attrReqs[i] = ar
+	}
+	attrs, err := attrmgr.ProcessAttributeRequests(attrReqs, user)
+	if err != nil {
+		return newServerError(ErrCodeNotAuthorized, "%s", err)
+	}
+	buf, err := attrs.Marshal()
+	if err != nil {
+		return err
+	}
+	req.Extensions = append(req.Extensions, signer.Extension{
+		ID:    config.OID(attrmgr.AttrOID),
+		Value: hex.EncodeToString(buf),
+	})
+	return nil
+}
+
+// selectProfile selects the profile for the request if none was requested
+// and returns true if the identity is entitled to the requested profile.
+// If the identity's type is bound to signing profiles by the CA's config,
+// the identity may request its type's profile, which is used by default, or
+// any of the type's other profiles.  Otherwise the identity may request the
+// default profile or the profiles in its "hf.AllowedProfiles" attribute,
+// except for CA and TLS server profiles, which require a binding.
+func (ca *CA) selectProfile(user spi.User, req *signer.SignRequest) bool {
+	idType := user.GetType()
+	binding, found := ca.Config.IdentityTypes[idType]
+	if found && binding.hasAffiliation(strings.Join(user.GetAffiliationPath(), ".")) {
+		if req.Profile == "" {
+			req.Profile = idType
+		}
+		return req.Profile == idType || util.StrContained(req.Profile, binding.Profiles)
+	}
+	if req.Profile == "" {
+		return true
+	}
+	if ca.isCAOrServerProfile(req.Profile) {
+		log.Debugf("Profile '%s' may only be requested by an identity type which is bound to it", req.Profile)
+		return false
+	}
+	return util.StrContained(req.Profile, splitAttrValue(user.GetAttribute(allowedProfilesAttr)))
+}
+
+// isCAOrServerProfile returns true if the named signing profile issues CA
+// certificates or TLS server certificates
+func (ca *CA) isCAOrServerProfile(name string) bool {
+	def := config.DefaultConfig()
+	if ca.Config.Signing != nil {
+		if ca.Config.Signing.Default != nil {
+			def = ca.Config.Signing.Default
+		}
+		if profile, found := ca.Config.Signing.Profiles[name]; found && profile != nil {
+			return profile.CAConstraint.IsCA || util.StrContained("server auth", profile.Usage)
+		}
+	}
+	idType, found := ca.Config.IdentityTypes[name]
+	if !found {
+		return false
+	}
+	usage := append(append([]string{}, idType.Usage...), idType.ExtUsage...)
+	if len(usage) == 0 {
+		usage = def.Usage
+	}
+	return idType.CAConstraint.IsCA || util.StrContained("server auth", usage)
+}
+
+// hasAffiliation returns true if the identity type binding applies to an
+// identity with the given affiliation
+func (it *ServerConfigIdentityType) hasAffiliation(affiliation string) bool {
+	if len(it.Affiliations) == 0 {
+		return true
+	}
+	for _, aff := range it.Affiliations {
+		if affiliation == aff || strings.HasPrefix(affiliation, aff+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// getSubjectNames returns the subject names for an identity, with an OU
+// for the identity's type and for each element of its affiliation path.
+// There is always at least one OU, and the other fields are always empty.
+func getSubjectNames(user spi.User) []csr.Name {
+	var names []csr.Name
+	ous := append([]string{user.GetType()}, user.GetAffiliationPath()...)
+	for _, ou := range ous {
+		if ou != "" {
+			names = append(names, csr.Name{OU: ou})
+		}
+	}
+	if len(names) == 0 {
+		names = append(names, csr.Name{OU: defaultSubjectOU})
+	}
+	return names
+}
+
+// ignoreCSRSubject returns a copy of the signing policy in which no profile
+// copies the subject from the CSR, so that the subject of a certificate is
+// only that of the sign request.  The other fields of the CSR are copied
+// as allowed by each profile's CSR whitelist.
+func ignoreCSRSubject(policy *config.Signing) *config.Signing {
+	ignore := func(profile *config.SigningProfile) *config.SigningProfile {
+		if profile == nil {
+			return nil
+		}
+		p := *profile
+		if profile.CSRWhitelist != nil {
+			wl := *profile.CSRWhitelist
+			p.CSRWhitelist = &wl
+		} else {
+			p.CSRWhitelist = &config.CSRWhitelist{
+				PublicKeyAlgorithm: true,
+				PublicKey:          true,
+				SignatureAlgorithm: true,
+				DNSNames:           true,
+				IPAddresses:        true,
+				EmailAddresses:     true,
+			}
+		}
+		p.CSRWhitelist.Subject = false
+		return &p
+	}
+	profiles := map[string]*config.SigningProfile{}
+	for name, profile := range policy.Profiles {
+		profiles[name] = ignore(profile)
+	}
+	return &config.Signing{Profiles: profiles, Default: ignore(policy.Default)}
+}
+
+// getRequestedHosts returns the hosts which will be included in the
+// certificate as SANs
+func getRequestedHosts(req *signer.SignRequest) ([]string, error) {
+	if req.Hosts != nil {
+		return req.Hosts, nil
+	}
+	block, _ := pem.Decode([]byte(req.Request))
+	if block == nil {
+		return nil, errors.New("Failed to decode the certificate request")
+	}
+	csrv, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse the certificate request: %s", err)
+	}
+	hosts := append([]string{}, csrv.DNSNames...)
+	for _, ip := range csrv.IPAddresses {
+		hosts = append(hosts, ip.String())
+	}
+	hosts = append(hosts, csrv.EmailAddresses...)
+	return hosts, nil
+}
+
+// hostAllowed returns true if host matches one of the allowed host patterns
+func hostAllowed(host string, allowed []string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(pattern)
+		if pattern == "*" || pattern == host {
+			return true
+		}
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitAttrValue splits a comma-separated attribute value
+func splitAttrValue(value string) []string {
+	var vals []string
+	for _, val := range strings.Split(value, ",") {
+		val = strings.TrimSpace(val)
+		if val != "" {
+			vals = append(vals, val)
+		}
+	}
+	return vals
+}
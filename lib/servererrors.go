@@ -18,37 +18,133 @@ package lib
 
 import (
 	"encoding/json"
-	"errors"
+	"fmt"
 	"net/http"
 
 	cfsslapi "github.com/cloudflare/cfssl/api"
 	"github.com/cloudflare/cfssl/log"
 )
 
+// Error codes which are returned by a fabric-ca server in error responses.
+// The codes are stable, so clients may rely on them.
+const (
+	// ErrCodeBadRequest means that the request is malformed or invalid
+	ErrCodeBadRequest = 10001
+	// ErrCodeAuthFailed means that the caller could not be authenticated
+	ErrCodeAuthFailed = 10002
+	// ErrCodeNotFound means that an object of the request was not found
+	ErrCodeNotFound = 10003
+	// ErrCodeInternal means that the server failed to handle the request
+	ErrCodeInternal = 10004
+	// ErrCodeNoAuthHeader means that the request has no authorization
+	// header, or an authorization header of a type which is not permitted
+	ErrCodeNoAuthHeader = 10005
+	// ErrCodeMaxEnrollments means that the identity has already been
+	// enrolled the maximum number of times
+	ErrCodeMaxEnrollments = 10006
+	// ErrCodeIdentityRevoked means that the identity has been revoked
+	ErrCodeIdentityRevoked = 10007
+	// ErrCodeNotAuthorized means that the caller is authenticated but is
+	// not authorized to make the request
+	ErrCodeNotAuthorized = 10008
+	// ErrCodeAlreadyRegistered means that the identity to be registered
+	// is already registered
+	ErrCodeAlreadyRegistered = 10009
+	// ErrCodeIdentityNotFound means that the identity was not found
+	ErrCodeIdentityNotFound = 10010
+	// ErrCodeAffiliationNotFound means that the affiliation was not found
+	ErrCodeAffiliationNotFound = 10011
+	// ErrCodeCertificateNotFound means that the certificate was not found
+	ErrCodeCertificateNotFound = 10012
+	// ErrCodeCANotFound means that the CA selected by the request does
+	// not exist
+	ErrCodeCANotFound = 10013
+)
+
+// errCodeStatus maps each error code to the HTTP status code of the
+// error responses which contain it
+var errCodeStatus = map[int]int{
+	ErrCodeBadRequest:          http.StatusBadRequest,
+	ErrCodeAuthFailed:          http.StatusUnauthorized,
+	ErrCodeNotFound:            http.StatusNotFound,
+	ErrCodeInternal:            http.StatusInternalServerError,
+	ErrCodeNoAuthHeader:        http.StatusUnauthorized,
+	ErrCodeMaxEnrollments:      http.StatusUnauthorized,
+	ErrCodeIdentityRevoked:     http.StatusUnauthorized,
+	ErrCodeNotAuthorized:       http.StatusForbidden,
+	ErrCodeAlreadyRegistered:   http.StatusConflict,
+	ErrCodeIdentityNotFound:    http.StatusNotFound,
+	ErrCodeAffiliationNotFound: http.StatusNotFound,
+	ErrCodeCertificateNotFound: http.StatusNotFound,
+	ErrCodeCANotFound:          http.StatusNotFound,
+}
+
 var (
-	errNoAuthHdr           = errors.New("No Authorization header was found")
-	errNoBasicAuthHdr      = errors.New("No Basic Authorization header was found")
-	errNoTokenAuthHdr      = errors.New("No Token Authorization header was found")
-	errBasicAuthNotAllowed = errors.New("Basic authorization is not permitted")
-	errTokenAuthNotAllowed = errors.New("Token authorization is not permitted")
-	errInvalidUserPass     = errors.New("Invalid user name or password")
-	errInputNotSeeker      = errors.New("Input stream was not a seeker")
+	errNoAuthHdr           = newServerError(ErrCodeNoAuthHeader, "No Authorization header was found")
+	errBasicAuthNotAllowed = newServerError(ErrCodeNoAuthHeader, "Basic authorization is not permitted")
+	errAuthFailed          = newServerError(ErrCodeAuthFailed, "Authorization failure")
 )
 
-func badRequest(w http.ResponseWriter, err error) error {
-	return httpError(w, 400, 10001, err.Error())
+// ServerError is an error which is returned by a fabric-ca server.  The
+// server sends it in an error response, from which the client returns it.
+type ServerError struct {
+	// Code is the fabric-ca error code, which is one of the ErrCode
+	// constants, or a cfssl error code for errors reported by cfssl
+	Code int
+	// StatusCode is the HTTP status code of the error response
+	StatusCode int
+	// Message describes the error
+	Message string
+}
+
+// Error returns the message and the code of the error
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("%s (code: %d)", e.Message, e.Code)
+}
+
+// newServerError returns an error with the code and a formatted message,
+// whose HTTP status code is that of the code
+func newServerError(code int, format string, args ...interface{}) *ServerError {
+	scode, ok := errCodeStatus[code]
+	if !ok {
+		scode = http.StatusInternalServerError
+	}
+	return &ServerError{Code: code, StatusCode: scode, Message: fmt.Sprintf(format, args...)}
 }
 
-func authErr(w http.ResponseWriter, err error) error {
-	return httpError(w, 401, 10002, err.Error())
+// errorHandler is a cfssl API handler which sends an error response for a
+// ServerError returned by the handler which it wraps
+type errorHandler struct {
+	next cfsslapi.Handler
 }
 
-func notFound(w http.ResponseWriter, err error) error {
-	return httpError(w, 404, 10003, err.Error())
+// newHTTPHandler returns an HTTP handler which calls the handler for
+// requests with one of the methods
+func newHTTPHandler(handler cfsslapi.Handler, methods ...string) http.Handler {
+	return &cfsslapi.HTTPHandler{
+		Handler: &errorHandler{next: handler},
+		Methods: methods,
+	}
 }
 
-func dbErr(w http.ResponseWriter, err error) error {
-	return httpError(w, 500, 10004, err.Error())
+// Handle a request and send the error response for a ServerError
+func (h *errorHandler) Handle(w http.ResponseWriter, r *http.Request) error {
+	err := h.next.Handle(w, r)
+	if _, ok := err.(*ServerError); ok {
+		handleError(w, err)
+		return nil
+	}
+	return err
+}
+
+// handleError sends the error response for an error, which cfssl sends
+// unless it is a ServerError
+func handleError(w http.ResponseWriter, err error) {
+	if se, ok := err.(*ServerError); ok {
+		httpError(w, se.StatusCode, se.Code, se.Message)
+		return
+	}
+	cfsslapi.HandleError(w, err)
 }
 
 func httpError(w http.ResponseWriter, scode, code int, msg string) error {
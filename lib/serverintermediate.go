@@ -0,0 +1,107 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/log"
+	"github.com/hyperledger/fabric-ca/api"
+)
+
+// getCACertFromParent enrolls the certificate of an intermediate CA with the
+// parent server, or with the parent server's CA named in the config, using
+// a CSR signed by the CA's key.  It returns the CA's certificate and the chain
+// consisting of the CA's certificate followed by the parent server's chain.
+// Note that the parent server issues the certificate to the enrollment ID
+// in the parent server's URL, which is therefore the CN of the certificate.
+func (ca *CA) getCACertFromParent(req *csr.CertificateRequest, signer crypto.Signer) (cert, chain []byte, err error) {
+	cfg := &ca.Config.Intermediate
+
+	parentURL, err := url.Parse(cfg.ParentServer.URL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid parent server URL: %s", err)
+	}
+	if parentURL.User == nil {
+		return nil, nil, errors.New("The parent server URL must contain an enrollment ID and secret")
+	}
+	name := parentURL.User.Username()
+	secret, _ := parentURL.User.Password()
+	if name == "" || secret == "" {
+		return nil, nil, errors.New("The parent server URL must contain an enrollment ID and secret")
+	}
+	parentURL.User = nil
+	tlsCfg := cfg.TLS
+	tlsCfg.EnableIfConfigured(parentURL.Scheme == "https", "parent server")
+
+	// The CSR must request a CA certificate
+	cr := &csr.CertificateRequest{
+		CN:           req.CN,
+		Names:        req.Names,
+		Hosts:        req.Hosts,
+		CA:           req.CA,
+		SerialNumber: req.SerialNumber,
+	}
+	if cr.CA == nil {
+		cr.CA = &csr.CAConfig{}
+	}
+
+	csrPEM, err := csr.Generate(signer, cr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to generate the intermediate CA's CSR: %s", err)
+	}
+
+	log.Infof("Enrolling intermediate CA certificate as '%s' with parent server %s", name, parentURL)
+	client := &Client{
+		HomeDir: ca.HomeDir,
+		Config: &ClientConfig{
+			URL:    parentURL.String(),
+			TLS:    tlsCfg,
+			CAName: cfg.ParentServer.CAName,
+		},
+	}
+	result, err := client.enroll(context.Background(), &api.EnrollmentRequest{
+		Name:    name,
+		Secret:  secret,
+		Profile: cfg.Profile,
+	}, csrPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to enroll with parent server: %s", err)
+	}
+	cert, err = parseCertResponse(result)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to enroll with parent server: %s", err)
+	}
+	info, err := client.GetCAInfo(&api.GetCAInfoRequest{CAName: cfg.ParentServer.CAName})
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to get the certificate chain of the parent server: %s", err)
+	}
+	parentChain := bytes.TrimSpace(info.CAChain)
+	if len(parentChain) == 0 {
+		return nil, nil, errors.New("The parent server did not return its certificate chain")
+	}
+	parentChain = append(parentChain, '\n')
+
+	chain = append(append([]byte{}, cert...), parentChain...)
+	return cert, chain, nil
+}
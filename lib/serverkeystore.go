@@ -0,0 +1,48 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"fmt"
+
+	"github.com/cloudflare/cfssl/log"
+)
+
+// RekeyKeystore re-encrypts the keys in the key store of the CA with the
+// given name, or of the default CA if the name is empty, with a new password.
+// The keys must be encrypted with the password in the CA's config, if any.
+// Only the key store of the software CSP can be rekeyed.
+func (s *Server) RekeyKeystore(caname string, newPassword []byte) error {
+	ca, err := s.getCAForMaintenance(caname)
+	if err != nil {
+		return err
+	}
+	cfg, err := ca.getCSPConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.SW == nil {
+		return fmt.Errorf("The key store of CA '%s' is not that of the software CSP", ca.Config.CA.Name)
+	}
+	err = cfg.SW.Rekey(newPassword)
+	if err != nil {
+		return err
+	}
+	log.Infof("Rekeyed the key store of CA '%s' in %s; set the new password in the CA's config "+
+		"before restarting the server", ca.Config.CA.Name, cfg.SW.KeyStoreDir)
+	return nil
+}
@@ -18,7 +18,6 @@ package lib
 
 import (
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
@@ -33,37 +32,40 @@ import (
 
 // registerHandler for register requests
 type registerHandler struct {
+	// Returns the CA in which identities are registered
+	getCA caGetter
 }
 
-// NewRegisterHandler is constructor for register handler
-func NewRegisterHandler() (h http.Handler, err error) {
-	// NewHandler is constructor for register handler
-	return &cfsslapi.HTTPHandler{
-		Handler: &registerHandler{},
-		Methods: []string{"POST"},
-	}, nil
+// newRegisterHandler is constructor for register handler
+func newRegisterHandler(getCA caGetter) (h http.Handler, err error) {
+	return newHTTPHandler(&registerHandler{getCA: getCA}, "POST"), nil
 }
 
 // Handle a register request
 func (h *registerHandler) Handle(w http.ResponseWriter, r *http.Request) error {
 	log.Debug("Register request received")
 
-	reqBody, err := ioutil.ReadAll(r.Body)
+	ca, err := h.getCA(r)
 	if err != nil {
 		return err
 	}
+
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return newServerError(ErrCodeBadRequest, "Failure reading request body: %s", err)
+	}
 	r.Body.Close()
 
 	// Parse request body
 	var req api.RegistrationRequestNet
 	err = json.Unmarshal(reqBody, &req)
 	if err != nil {
-		return err
+		return newServerError(ErrCodeBadRequest, "Failed to parse register request: %s", err)
 	}
 
 	// Register User
 	callerID := r.Header.Get(enrollmentIDHdrName)
-	tok, err := h.RegisterUser(req.Name, req.Type, req.Group, req.Attributes, callerID)
+	tok, err := h.RegisterUser(ca, req.Name, req.Type, req.Group, req.Attributes, callerID)
 	if err != nil {
 		return err
 	}
@@ -74,8 +76,8 @@ func (h *registerHandler) Handle(w http.ResponseWriter, r *http.Request) error {
 	return cfsslapi.SendResponse(w, resp)
 }
 
-// RegisterUser will register a user
-func (h *registerHandler) RegisterUser(id string, userType string, group string, attributes []api.Attribute, registrar string, opt ...string) (string, error) {
+// RegisterUser will register a user with a CA
+func (h *registerHandler) RegisterUser(ca *CA, id string, userType string, group string, attributes []api.Attribute, registrar string, opt ...string) (string, error) {
 	log.Debugf("Received request to register user with id: %s, group: %s, attributes: %s, registrar: %s\n",
 		id, group, attributes, registrar)
 
@@ -84,20 +86,20 @@ func (h *registerHandler) RegisterUser(id string, userType string, group string,
 
 	if registrar != "" {
 		// Check the permissions of member named 'registrar' to perform this registration
-		err = h.canRegister(registrar, userType)
+		err = h.canRegister(ca, registrar, userType, attributes)
 		if err != nil {
 			log.Debugf("Registration of '%s' failed: %s", id, err)
 			return "", err
 		}
 	}
 
-	err = h.validateID(id, userType, group)
+	err = h.validateID(ca, id, userType, group)
 	if err != nil {
 		log.Debugf("Registration of '%s' failed: %s", id, err)
 		return "", err
 	}
 
-	tok, err = h.registerUserID(id, userType, group, attributes, opt...)
+	tok, err = h.registerUserID(ca, id, userType, group, attributes, opt...)
 
 	if err != nil {
 		log.Debugf("Registration of '%s' failed: %s", id, err)
@@ -107,12 +109,12 @@ func (h *registerHandler) RegisterUser(id string, userType string, group string,
 	return tok, nil
 }
 
-func (h *registerHandler) validateID(id string, userType string, group string) error {
+func (h *registerHandler) validateID(ca *CA, id string, userType string, group string) error {
 	log.Debug("Validate ID")
 	// Check whether the affiliation group is required for the current user.
 	if h.requireGroup(userType) {
 		// If yes, is the group valid
-		err := h.isValidGroup(group)
+		err := h.isValidGroup(ca, group)
 		if err != nil {
 			return err
 		}
@@ -121,7 +123,7 @@ func (h *registerHandler) validateID(id string, userType string, group string) e
 }
 
 // registerUserID registers a new user and its enrollmentID, role and state
-func (h *registerHandler) registerUserID(id string, userType string, group string, attributes []api.Attribute, opt ...string) (string, error) {
+func (h *registerHandler) registerUserID(ca *CA, id string, userType string, group string, attributes []api.Attribute, opt ...string) (string, error) {
 	log.Debugf("Registering user id: %s\n", id)
 
 	var tok string
@@ -137,28 +139,28 @@ func (h *registerHandler) registerUserID(id string, userType string, group strin
 		Type:           userType,
 		Group:          group,
 		Attributes:     attributes,
-		MaxEnrollments: MaxEnrollments,
+		MaxEnrollments: ca.Config.Registry.MaxEnrollments,
 	}
 
-	_, err := UserRegistry.GetUser(id, nil)
+	_, err := ca.registry.GetUser(id, nil)
 	if err == nil {
-		return "", fmt.Errorf("User '%s' is already registered", id)
+		return "", newServerError(ErrCodeAlreadyRegistered, "User '%s' is already registered", id)
 	}
 
-	err = UserRegistry.InsertUser(insert)
+	err = ca.registry.InsertUser(insert)
 	if err != nil {
-		return "", err
+		return "", newServerError(ErrCodeInternal, "Failed to insert user '%s': %s", id, err)
 	}
 
 	return tok, nil
 }
 
-func (h *registerHandler) isValidGroup(group string) error {
+func (h *registerHandler) isValidGroup(ca *CA, group string) error {
 	log.Debug("Validating group: " + group)
 
-	_, err := UserRegistry.GetGroup(group)
+	_, err := ca.registry.GetGroup(group)
 	if err != nil {
-		return fmt.Errorf("Failed getting affiliation group '%s': %s", group, err)
+		return newServerError(ErrCodeAffiliationNotFound, "Failed getting affiliation group '%s': %s", group, err)
 	}
 
 	return nil
@@ -170,12 +172,12 @@ func (h *registerHandler) requireGroup(idType string) bool {
 	return true
 }
 
-func (h *registerHandler) canRegister(registrar string, userType string) error {
+func (h *registerHandler) canRegister(ca *CA, registrar string, userType string, attributes []api.Attribute) error {
 	log.Debugf("canRegister - Check to see if user %s can register", registrar)
 
-	user, err := UserRegistry.GetUser(registrar, nil)
+	user, err := ca.registry.GetUser(registrar, nil)
 	if err != nil {
-		return fmt.Errorf("Registrar does not exist: %s", err)
+		return newServerError(ErrCodeNotAuthorized, "Registrar does not exist: %s", err)
 	}
 
 	var roles []string
@@ -186,8 +188,40 @@ func (h *registerHandler) canRegister(registrar string, userType string) error {
 		roles = make([]string, 0)
 	}
 	if !util.StrContained(userType, roles) {
-		return fmt.Errorf("User '%s' may not register type '%s'", registrar, userType)
+		return newServerError(ErrCodeNotAuthorized, "User '%s' may not register type '%s'", registrar, userType)
+	}
+
+	for _, attr := range attributes {
+		if !canGrantAttribute(user, attr) {
+			return newServerError(ErrCodeNotAuthorized, "User '%s' may not register an identity with attribute '%s=%s'",
+				registrar, attr.Name, attr.Value)
+		}
 	}
 
 	return nil
 }
+
+// canGrantAttribute returns true if the registrar may give the attribute to
+// the identity being registered.  The "hf.AllowedHosts" and
+// "hf.AllowedProfiles" attributes limit the certificates which an identity
+// may enroll for, so the registrar may only give hosts and profiles which it
+// is allowed itself; any other attribute may be given.
+func canGrantAttribute(registrar spi.User, attr api.Attribute) bool {
+	switch attr.Name {
+	case allowedHostsAttr:
+		held := splitAttrValue(registrar.GetAttribute(allowedHostsAttr))
+		for _, host := range splitAttrValue(attr.Value) {
+			if !hostAllowed(host, held) {
+				return false
+			}
+		}
+	case allowedProfilesAttr:
+		held := splitAttrValue(registrar.GetAttribute(allowedProfilesAttr))
+		for _, profile := range splitAttrValue(attr.Value) {
+			if !util.StrContained(profile, held) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,194 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+
+	"github.com/cloudflare/cfssl/log"
+	"github.com/hyperledger/fabric-ca/util"
+)
+
+// Reload applies a new config to the running server without dropping its
+// clients.  The signing policy, identity types, registry and affiliations of
+// each CA are reloaded, and its registry is synced with the new identities and
+// affiliations.  The server's TLS certificate and key files, and its debug and
+// shutdown timeout settings, are also reloaded.
+// Changes to the rest of the config take effect when the server is restarted,
+// and a reload which adds or removes a CA fails.
+// The new config of every CA, including its registry sync, is validated in
+// full before any registry is changed or any config is applied, and requests
+// are served with either the old or the new config, never a mix of both.  A
+// reload which fails leaves the old config running; if a registry fails
+// after others were synced, the error names the CAs whose registries were.
+func (s *Server) Reload(cfg *ServerConfig) error {
+	s.reloadMutex.Lock()
+	defer s.reloadMutex.Unlock()
+	if s.listener == nil {
+		return errors.New("server is not currently started")
+	}
+	log.Info("Reloading the server's config")
+
+	// Load the new TLS certificate
+	if cfg.TLS.CertFile == "" {
+		cfg.TLS.CertFile = DefaultTLSCertFile
+	}
+	if cfg.TLS.KeyFile == "" {
+		cfg.TLS.KeyFile = DefaultTLSKeyFile
+	}
+	tlsFiles := []*string{&cfg.TLS.CertFile, &cfg.TLS.KeyFile}
+	err := makeFileNamesAbsolute(tlsFiles, s.HomeDir)
+	if err != nil {
+		return err
+	}
+	var tlsCert *tls.Certificate
+	if s.Config.TLS.Enabled {
+		tlsCert, err = s.loadTLSCert(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return fmt.Errorf("Failed to reload TLS certificate: %s", err)
+		}
+	}
+
+	// Stage the new config of each CA; that of each additional CA is read
+	// from its file in the new config's CA config files, which must name the
+	// CAs which are running
+	reloads := []*caReload{}
+	reload, err := s.CA.stageReload(&cfg.CAConfig)
+	if err != nil {
+		return err
+	}
+	reloads = append(reloads, reload)
+	reloaded := map[string]bool{}
+	for _, file := range cfg.CAfiles {
+		file, err := util.MakeFileAbs(file, s.HomeDir)
+		if err != nil {
+			return err
+		}
+		newCA, err := newCAFromFile(file)
+		if err != nil {
+			return err
+		}
+		name := newCA.Config.CA.Name
+		current, found := s.caMap[name]
+		if !found {
+			return fmt.Errorf("CA '%s' in CA config file '%s' is not running; "+
+				"the server must be restarted to add it", name, file)
+		}
+		if reloaded[name] {
+			return fmt.Errorf("CA name '%s' in CA config file '%s' is already in use", name, file)
+		}
+		reloaded[name] = true
+		reload, err := current.stageReload(newCA.Config)
+		if err != nil {
+			return err
+		}
+		reloads = append(reloads, reload)
+	}
+	for name := range s.caMap {
+		if !reloaded[name] {
+			return fmt.Errorf("CA '%s' is not in the CA config files of the new config; "+
+				"the server must be restarted to remove it", name)
+		}
+	}
+
+	// Add the new identities and affiliations to the registries.  Every CA's
+	// sync was validated when it was staged, so this only fails if a
+	// registry does; the registries which were already synced keep their
+	// changes, so report them
+	synced := []string{}
+	for _, reload := range reloads {
+		_, err = reload.staged.syncRegistry()
+		if err != nil {
+			if len(synced) > 0 {
+				return fmt.Errorf("%s; the registries of CAs %v were already synced, but the new config was not applied", err, synced)
+			}
+			return err
+		}
+		synced = append(synced, reload.ca.Config.CA.Name)
+	}
+
+	// Apply the new config
+	s.configMutex.Lock()
+	for _, reload := range reloads {
+		reload.apply()
+	}
+	s.Config.TLS.CertFile = cfg.TLS.CertFile
+	s.Config.TLS.KeyFile = cfg.TLS.KeyFile
+	s.Config.Debug = cfg.Debug
+	s.Config.ShutdownTimeout = cfg.ShutdownTimeout
+	if cfg.Debug {
+		log.Level = log.LevelDebug
+	} else {
+		log.Level = log.LevelInfo
+	}
+	s.configMutex.Unlock()
+	if tlsCert != nil {
+		s.tlsCert.Store(tlsCert)
+	}
+	log.Info("Reloaded the server's config")
+	return nil
+}
+
+// caReload is a reload of the config of a CA which has been staged
+type caReload struct {
+	// The CA being reloaded
+	ca *CA
+	// A copy of the CA with the new config and enrollment signer
+	staged *CA
+}
+
+// stageReload returns a copy of the CA with the reloadable parts of the new
+// config, and an enrollment signer for it, after validating them
+func (ca *CA) stageReload(cfg *CAConfig) (*caReload, error) {
+	name := ca.Config.CA.Name
+	newCfg := *ca.Config
+	newCfg.Signing = cfg.Signing
+	newCfg.IdentityTypes = cfg.IdentityTypes
+	newCfg.Registry = cfg.Registry
+	newCfg.Affiliations = cfg.Affiliations
+	staged := &CA{
+		HomeDir:        ca.HomeDir,
+		Config:         &newCfg,
+		csp:            ca.csp,
+		certDBAccessor: ca.certDBAccessor,
+		registry:       ca.registry,
+		cert:           ca.cert,
+	}
+	var err error
+	staged.enrollSigner, err = staged.newEnrollmentSigner()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid signing config of CA '%s': %s", name, err)
+	}
+	err = staged.validateSync()
+	if err != nil {
+		return nil, err
+	}
+	return &caReload{ca: ca, staged: staged}, nil
+}
+
+// apply the staged config to the CA being reloaded
+func (r *caReload) apply() {
+	cfg := r.staged.Config
+	r.ca.Config.Signing = cfg.Signing
+	r.ca.Config.IdentityTypes = cfg.IdentityTypes
+	r.ca.Config.Registry = cfg.Registry
+	r.ca.Config.Affiliations = cfg.Affiliations
+	r.ca.enrollSigner = r.staged.enrollSigner
+	log.Infof("Reloaded the config of CA '%s'", cfg.CA.Name)
+}
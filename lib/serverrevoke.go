@@ -18,10 +18,10 @@ package lib
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 
 	cfsslapi "github.com/cloudflare/cfssl/api"
 	"github.com/cloudflare/cfssl/log"
@@ -31,16 +31,15 @@ import (
 	"github.com/hyperledger/fabric-ca/util"
 )
 
-// NewRevokeHandler is constructor for revoke handler
-func NewRevokeHandler() (h http.Handler, err error) {
-	// NewHandler is constructor for register handler
-	return &cfsslapi.HTTPHandler{
-		Handler: &revokeHandler{},
-		Methods: []string{"POST"}}, nil
+// newRevokeHandler is constructor for revoke handler
+func newRevokeHandler(getCA caGetter) (h http.Handler, err error) {
+	return newHTTPHandler(&revokeHandler{getCA: getCA}, "POST"), nil
 }
 
 // revokeHandler for revoke requests
 type revokeHandler struct {
+	// Returns the CA whose certificates are revoked
+	getCA caGetter
 }
 
 // Handle an revoke request
@@ -50,83 +49,252 @@ func (h *revokeHandler) Handle(w http.ResponseWriter, r *http.Request) error {
 
 	authHdr := r.Header.Get("authorization")
 	if authHdr == "" {
-		return authErr(w, errors.New("no authorization header"))
+		return errNoAuthHdr
+	}
+
+	ca, err := h.getCA(r)
+	if err != nil {
+		return err
 	}
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return badRequest(w, err)
+		return newServerError(ErrCodeBadRequest, "Failure reading request body: %s", err)
 	}
 	r.Body.Close()
 
-	cert, err := util.VerifyToken(MyCSP, authHdr, body)
+	cert, err := util.VerifyToken(ca.csp, authHdr, body)
 	if err != nil {
-		return authErr(w, err)
+		return newServerError(ErrCodeAuthFailed, "%s", err)
 	}
 
 	// Make sure that the user has the "hf.Revoker" attribute in order to be authorized
 	// to revoke a certificate.  This attribute comes from the user registry, which
 	// is either in the DB if LDAP is not configured, or comes from LDAP if LDAP is
 	// configured.
-	err = userHasAttribute(cert.Subject.CommonName, "hf.Revoker")
+	revoker := cert.Subject.CommonName
+	err = ca.userHasAttribute(revoker, "hf.Revoker")
 	if err != nil {
-		return authErr(w, err)
+		return newServerError(ErrCodeNotAuthorized, "%s", err)
 	}
 
 	// Parse revoke request body
 	var req api.RevocationRequestNet
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		return badRequest(w, err)
+		return newServerError(ErrCodeBadRequest, "Failed to parse revoke request: %s", err)
 	}
 
 	log.Debugf("Revoke request: %+v", req)
 
+	var result *api.RevocationResponse
+
 	if req.Serial != "" && req.AKI != "" {
-		err = MyCertDBAccessor.RevokeCertificate(req.Serial, req.AKI, req.Reason)
+		result, err = ca.revokeCertificate(revoker, req.Serial, req.AKI, req.Reason, req.DryRun)
 		if err != nil {
-			return notFound(w, err)
+			return err
 		}
 	} else if req.Name != "" {
 
-		user, err := UserRegistry.GetUser(req.Name, nil)
+		err = ca.checkIdentitiesRevocable()
 		if err != nil {
-			err = fmt.Errorf("Failed to get user %s: %s", req.Name, err)
-			return notFound(w, err)
+			return err
 		}
 
-		// Set user state to -1 for revoked user
-		if user != nil {
-			var userInfo spi.UserInfo
-			userInfo, err = UserRegistry.GetUserInfo(req.Name)
-			if err != nil {
-				err = fmt.Errorf("Failed to get user info %s: %s", req.Name, err)
-				return notFound(w, err)
-			}
+		var user spi.User
+		user, err = ca.registry.GetUser(req.Name, nil)
+		if err != nil {
+			return newServerError(ErrCodeIdentityNotFound, "Failed to get user %s: %s", req.Name, err)
+		}
 
-			userInfo.State = -1
+		err = ca.checkRevokerAffiliation(revoker, getAffiliation(user))
+		if err != nil {
+			return err
+		}
 
-			err = UserRegistry.UpdateUser(userInfo)
-			if err != nil {
-				log.Warningf("Revoke failed: %s", err)
-				return dbErr(w, err)
-			}
+		result, err = ca.revokeIdentities([]string{req.Name}, req.Reason, req.DryRun)
+		if err != nil {
+			log.Warningf("Revoke failed: %s", err)
+			return newServerError(ErrCodeInternal, "%s", err)
 		}
 
-		var recs []CertRecord
-		recs, err = MyCertDBAccessor.RevokeCertificatesByID(req.Name, req.Reason)
+	} else if req.Affiliation != "" {
+
+		err = ca.checkIdentitiesRevocable()
+		if err != nil {
+			return err
+		}
+
+		_, err = ca.registry.GetGroup(req.Affiliation)
+		if err != nil {
+			return newServerError(ErrCodeAffiliationNotFound, "Failed to get affiliation %s: %s", req.Affiliation, err)
+		}
+
+		err = ca.checkRevokerAffiliation(revoker, req.Affiliation)
+		if err != nil {
+			return err
+		}
+
+		var users []spi.UserInfo
+		users, err = ca.registry.GetGroupUsers(req.Affiliation)
+		if err != nil {
+			return newServerError(ErrCodeInternal, "Failed to get users in affiliation %s: %s", req.Affiliation, err)
+		}
+
+		ids := make([]string, len(users))
+		for idx, user := range users {
+			ids[idx] = user.Name
+		}
+
+		result, err = ca.revokeIdentities(ids, req.Reason, req.DryRun)
 		if err != nil {
-			log.Warningf("No certificates were revoked for '%s' but the ID was disabled: %s", req.Name, err)
-			return dbErr(w, err)
+			log.Warningf("Revoke of affiliation '%s' failed: %s", req.Affiliation, err)
+			return newServerError(ErrCodeInternal, "%s", err)
 		}
-		log.Debugf("Revoked the following certificates owned by '%s': %+v", req.Name, recs)
 
 	} else {
-		return badRequest(w, errors.New("Either Name or Serial and AKI are required for a revoke request"))
+		return newServerError(ErrCodeBadRequest, "Either Name, Serial and AKI, or Affiliation are required for a revoke request")
+	}
+
+	log.Debugf("Revoke was successful: %+v; result: %+v", req, result)
+
+	resp := &api.RevocationResponseNet{RevocationResponse: *result}
+	return cfsslapi.SendResponse(w, resp)
+}
+
+// revokeCertificate revokes a single certificate on behalf of 'revoker', or
+// only reports what would be revoked if dryRun is true.  In both modes, the
+// owner of the certificate must be in the revoker's affiliation, and a
+// certificate which is already revoked is not reported.  Affiliations are only
+// checked if the registry is the database, since the affiliation path of an
+// LDAP identity is its DN.
+func (ca *CA) revokeCertificate(revoker, serial, aki string, reason int, dryRun bool) (*api.RevocationResponse, error) {
+	recs, err := ca.certDBAccessor.GetCertificateWithID(serial, aki)
+	if err != nil {
+		return nil, newServerError(ErrCodeInternal, "Failed to get certificate: %s", err)
+	}
+	if len(recs) == 0 {
+		return nil, newServerError(ErrCodeCertificateNotFound, "failed to revoke the certificate: certificate not found")
+	}
+	rec := recs[0]
+
+	if ca.hasDBRegistry() {
+		owner, err := ca.registry.GetUser(rec.ID, nil)
+		if err != nil {
+			return nil, newServerError(ErrCodeIdentityNotFound, "Failed to get owner '%s' of the certificate: %s", rec.ID, err)
+		}
+		err = ca.checkRevokerAffiliation(revoker, getAffiliation(owner))
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	log.Debugf("Revoke was successful: %+v", req)
+	result := &api.RevocationResponse{
+		RevokedCerts: make([]api.RevokedCert, 0, 1),
+		DryRun:       dryRun,
+	}
+	if rec.Status == "revoked" {
+		log.Debugf("Certificate with serial %s and AKI %s is already revoked", serial, aki)
+		return result, nil
+	}
+	if !dryRun {
+		err = ca.certDBAccessor.RevokeCertificate(serial, aki, reason)
+		if err != nil {
+			return nil, newServerError(ErrCodeInternal, "Failed to revoke certificate: %s", err)
+		}
+	}
+	result.RevokedCerts = append(result.RevokedCerts, api.RevokedCert{Serial: serial, AKI: aki})
+	return result, nil
+}
+
+// checkRevokerAffiliation returns an error unless 'affiliation' is the
+// affiliation of the revoker or below it.  A revoker without an affiliation
+// may revoke in any affiliation.
+func (ca *CA) checkRevokerAffiliation(revoker, affiliation string) error {
+	user, err := ca.registry.GetUser(revoker, nil)
+	if err != nil {
+		return newServerError(ErrCodeNotAuthorized, "Failed to get revoker '%s': %s", revoker, err)
+	}
+	revokerAffiliation := getAffiliation(user)
+	if revokerAffiliation == "" || affiliation == revokerAffiliation ||
+		strings.HasPrefix(affiliation, revokerAffiliation+".") {
+		return nil
+	}
+	return newServerError(ErrCodeNotAuthorized, "Revoker '%s' may not revoke in affiliation '%s' which is not within its affiliation '%s'",
+		revoker, affiliation, revokerAffiliation)
+}
+
+// hasDBRegistry returns true if the registry of the CA is its database, as
+// opposed to an LDAP directory
+func (ca *CA) hasDBRegistry() bool {
+	_, ok := ca.registry.(*Accessor)
+	return ok
+}
+
+// checkIdentitiesRevocable returns an error unless identities may be revoked
+// by name or affiliation, which disables them in the users table of the
+// database.  The identities of an LDAP directory are not in that table, so
+// only their certificates may be revoked.
+func (ca *CA) checkIdentitiesRevocable() error {
+	if ca.hasDBRegistry() {
+		return nil
+	}
+	return newServerError(ErrCodeBadRequest, "Identities can not be revoked by name or affiliation unless the registry is the database; revoke their certificates by serial and AKI instead")
+}
+
+// getAffiliation returns the affiliation of an identity as a dot-separated
+// string
+func getAffiliation(user spi.User) string {
+	return strings.Join(user.GetAffiliationPath(), ".")
+}
+
+// revokeIdentities disables each identity and revokes all of its certificates
+// in a single transaction.  If dryRun is true, the transaction is rolled back
+// so that the result describes what would have been revoked.
+func (ca *CA) revokeIdentities(ids []string, reason int, dryRun bool) (result *api.RevocationResponse, err error) {
+	err = ca.certDBAccessor.checkDB()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := ca.certDBAccessor.db.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to begin transaction: %s", err)
+	}
+	defer func() {
+		if err != nil || dryRun {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+		if err != nil {
+			result = nil
+			err = fmt.Errorf("Failed to commit transaction: %s", err)
+		}
+	}()
+
+	result = &api.RevocationResponse{
+		Identities:   make([]string, 0, len(ids)),
+		RevokedCerts: make([]api.RevokedCert, 0),
+		DryRun:       dryRun,
+	}
+	for _, id := range ids {
+		err = disableUserTx(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		result.Identities = append(result.Identities, id)
+
+		var recs []CertRecord
+		recs, err = revokeCertificatesByIDTx(tx, id, reason)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to revoke certificates owned by '%s': %s", id, err)
+		}
+		for _, rec := range recs {
+			result.RevokedCerts = append(result.RevokedCerts, api.RevokedCert{Serial: rec.Serial, AKI: rec.AKI})
+		}
+		log.Debugf("Revoked the following certificates owned by '%s': %+v", id, recs)
+	}
 
-	result := map[string]string{}
-	return cfsslapi.SendResponse(w, result)
+	return result, nil
 }
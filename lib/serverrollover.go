@@ -0,0 +1,320 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudflare/cfssl/log"
+	libcsp "github.com/hyperledger/fabric-ca/lib/csp"
+	"github.com/hyperledger/fabric-ca/util"
+)
+
+// A rollover replaces the key and certificate of a CA without invalidating
+// the certificates issued under the previous key.  It has two phases:
+// 1) Starting the rollover generates a new key and certificate, which are
+//    used to issue certificates from then on.  The previous certificate
+//    and, for a root CA, the previous and new certificates cross-signed by
+//    each other's key are returned after the CA's chain, and certificates
+//    issued under the previous key still authenticate requests.
+// 2) Finishing the rollover, once identities have reenrolled, removes the
+//    previous key and certificate and the cross-signed certificates, so that
+//    certificates issued under the previous key are no longer accepted.
+// The server must be restarted after each phase for it to take effect.
+
+// StartRollover starts a rollover of the key of the CA with the given name,
+// or of the default CA if the name is empty
+func (s *Server) StartRollover(caname string) error {
+	ca, err := s.getCAForMaintenance(caname)
+	if err != nil {
+		return err
+	}
+	return ca.startRollover()
+}
+
+// FinishRollover finishes the rollover of the key of the CA with the given
+// name, or of the default CA if the name is empty
+func (s *Server) FinishRollover(caname string) error {
+	ca, err := s.getCAForMaintenance(caname)
+	if err != nil {
+		return err
+	}
+	return ca.finishRollover()
+}
+
+// Initialize only as much of the server as is needed to maintain a CA, such
+// as rolling over its key, and return the CA
+func (s *Server) getCAForMaintenance(caname string) (*CA, error) {
+	err := s.initConfig()
+	if err != nil {
+		return nil, err
+	}
+	err = s.loadCAs()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetCA(caname)
+}
+
+// Start a rollover of the CA's key
+func (ca *CA) startRollover() error {
+	cfg := &ca.Config.CA
+	if util.FileExists(cfg.Rollover.PreviousCertfile) {
+		return fmt.Errorf("A rollover of the key of CA '%s' is already in progress", cfg.Name)
+	}
+	err := ca.initCSP()
+	if err != nil {
+		return err
+	}
+	// The previous key stays in the BCCSP; its key file references it
+	err = ca.importKeyFile()
+	if err != nil {
+		return err
+	}
+	prevCertPEM, err := ioutil.ReadFile(cfg.Certfile)
+	if err != nil {
+		return fmt.Errorf("Failed to read certificate: %s", err)
+	}
+	prevKeySKI, err := ioutil.ReadFile(cfg.Keyfile)
+	if err != nil {
+		return fmt.Errorf("Failed to read key: %s", err)
+	}
+
+	// Generate the new key and certificate
+	cert, key, chain, err := ca.genKeyAndCert()
+	if err != nil {
+		return err
+	}
+
+	// A root CA's certificates are cross-signed so that certificates issued
+	// under either key verify against either root certificate; those of an
+	// intermediate CA are both issued by the parent CA
+	var cross []byte
+	if ca.Config.Intermediate.ParentServer.URL == "" {
+		prevSigner, err := libcsp.GetSignerFromSKIFile(cfg.Keyfile, ca.csp)
+		if err != nil {
+			return fmt.Errorf("Failed to get the signer of the previous key: %s", err)
+		}
+		signer, err := libcsp.GetSigner(ca.csp, key)
+		if err != nil {
+			return fmt.Errorf("Failed to get the signer of the new key: %s", err)
+		}
+		cross, err = crossSignCerts(prevCertPEM, prevSigner, cert, signer)
+		if err != nil {
+			return err
+		}
+	}
+	cross = append(cross, prevCertPEM...)
+
+	// Keep the previous key and certificate and switch to the new ones
+	newFiles := []*rolloverFile{
+		{name: cfg.Keyfile, buf: libcsp.SKIToPEM(key), perm: 0600, what: "key"},
+		{name: cfg.Certfile, buf: cert, perm: 0644, what: "certificate"},
+		{name: cfg.Chainfile, buf: chain, perm: 0644, what: "certificate chain"},
+	}
+	rolloverFiles := []*rolloverFile{
+		{name: cfg.Rollover.PreviousKeyfile, buf: prevKeySKI, perm: 0600, what: "previous key"},
+		{name: cfg.Rollover.Crossfile, buf: cross, perm: 0644, what: "cross-signed certificates"},
+		{name: cfg.Rollover.PreviousCertfile, buf: prevCertPEM, perm: 0644, what: "previous certificate"},
+	}
+	err = storeRolloverFiles(newFiles, rolloverFiles)
+	if err != nil {
+		return err
+	}
+	log.Infof("Started rollover of the key of CA '%s'; the previous certificate is in %s",
+		cfg.Name, cfg.Rollover.PreviousCertfile)
+	return nil
+}
+
+// A file which is written by starting a rollover
+type rolloverFile struct {
+	name string
+	buf  []byte
+	perm os.FileMode
+	what string
+	// The staged new contents of the file, and its previous contents if
+	// it existed
+	tmp      string
+	prev     []byte
+	existed  bool
+	replaced bool
+}
+
+// Store the files of a rollover: 'newFiles' replace the CA's key material
+// and 'rolloverFiles' keep the previous key and certificate.  The new key
+// material is staged in temporary files first, then the rollover files are
+// written, and the new key material is moved into place last.  On failure,
+// the rollover files are removed and the CA's files restored, so that the
+// CA keeps its previous key with no rollover in progress.
+func storeRolloverFiles(newFiles, rolloverFiles []*rolloverFile) (err error) {
+	defer func() {
+		for _, f := range newFiles {
+			if f.tmp != "" {
+				os.Remove(f.tmp)
+			}
+		}
+		if err == nil {
+			return
+		}
+		for _, f := range rolloverFiles {
+			os.Remove(f.name)
+		}
+		for _, f := range newFiles {
+			if f.replaced {
+				var rerr error
+				if f.existed {
+					rerr = util.WriteFileAtomically(f.name, f.prev, f.perm)
+				} else {
+					rerr = os.Remove(f.name)
+				}
+				if rerr != nil {
+					log.Errorf("Failed to restore %s %s: %s", f.what, f.name, rerr)
+				}
+			}
+		}
+	}()
+	for _, f := range newFiles {
+		f.prev, err = ioutil.ReadFile(f.name)
+		if err == nil {
+			f.existed = true
+		} else if os.IsNotExist(err) {
+			err = os.MkdirAll(filepath.Dir(f.name), 0755)
+			if err != nil {
+				return fmt.Errorf("Failed to create directory of %s: %s", f.what, err)
+			}
+		} else {
+			return fmt.Errorf("Failed to read %s: %s", f.what, err)
+		}
+		f.tmp, err = util.WriteTempFile(f.name, f.buf, f.perm)
+		if err != nil {
+			return fmt.Errorf("Failed to store %s: %s", f.what, err)
+		}
+	}
+	for _, f := range rolloverFiles {
+		err = writeFile(f.name, f.buf, f.perm)
+		if err != nil {
+			return fmt.Errorf("Failed to store %s: %s", f.what, err)
+		}
+	}
+	for _, f := range newFiles {
+		err = os.Rename(f.tmp, f.name)
+		if err != nil {
+			return fmt.Errorf("Failed to store %s: %s", f.what, err)
+		}
+		f.tmp = ""
+		f.replaced = true
+		log.Infof("Stored %s in %s", f.what, f.name)
+	}
+	return nil
+}
+
+// Finish the rollover of the CA's key
+func (ca *CA) finishRollover() error {
+	cfg := &ca.Config.CA
+	if !util.FileExists(cfg.Rollover.PreviousCertfile) {
+		return fmt.Errorf("No rollover of the key of CA '%s' is in progress", cfg.Name)
+	}
+	files := []string{
+		cfg.Rollover.Crossfile,
+		cfg.Rollover.PreviousKeyfile,
+		cfg.Rollover.PreviousCertfile,
+	}
+	for _, file := range files {
+		err := os.Remove(file)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Failed to remove '%s': %s", file, err)
+		}
+	}
+	log.Infof("Finished rollover of the key of CA '%s'", cfg.Name)
+	return nil
+}
+
+// Load the state of a rollover of the CA's key which is in progress
+func (ca *CA) initRollover() error {
+	cfg := &ca.Config.CA.Rollover
+	ca.previousCert = nil
+	if !util.FileExists(cfg.PreviousCertfile) {
+		return nil
+	}
+	cert, err := ioutil.ReadFile(cfg.PreviousCertfile)
+	if err != nil {
+		return fmt.Errorf("Failed to read previous certificate: %s", err)
+	}
+	ca.previousCert, err = BytesToX509Cert(cert)
+	if err != nil {
+		return fmt.Errorf("Failed to parse previous certificate: %s", err)
+	}
+	cross, err := ioutil.ReadFile(cfg.Crossfile)
+	if err != nil {
+		return fmt.Errorf("Failed to read cross-signed certificates: %s", err)
+	}
+	ca.chain = append(ca.chain, cross...)
+	log.Infof("A rollover of the key of CA '%s' is in progress; certificates issued "+
+		"under the previous key are accepted until it is finished", ca.Config.CA.Name)
+	return nil
+}
+
+// crossSignCerts returns the new certificate reissued under the previous
+// key followed by the previous certificate reissued under the new key
+func crossSignCerts(prevCertPEM []byte, prevKey crypto.Signer, certPEM []byte, key crypto.Signer) ([]byte, error) {
+	prevCert, err := BytesToX509Cert(prevCertPEM)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse previous certificate: %s", err)
+	}
+	cert, err := BytesToX509Cert(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse certificate: %s", err)
+	}
+	newWithPrev, err := crossSignCert(cert, prevCert, prevKey)
+	if err != nil {
+		return nil, err
+	}
+	prevWithNew, err := crossSignCert(prevCert, cert, key)
+	if err != nil {
+		return nil, err
+	}
+	return append(newWithPrev, prevWithNew...), nil
+}
+
+// crossSignCert returns cert reissued by issuer, whose key is issuerKey.
+// The reissued certificate doesn't outlive the issuer's certificate.
+func crossSignCert(cert, issuer *x509.Certificate, issuerKey crypto.Signer) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to generate serial number: %s", err)
+	}
+	template := *cert
+	template.SerialNumber = serial
+	template.SignatureAlgorithm = x509.UnknownSignatureAlgorithm
+	template.AuthorityKeyId = nil
+	if template.NotAfter.After(issuer.NotAfter) {
+		template.NotAfter = issuer.NotAfter
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, issuer, cert.PublicKey, issuerKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to cross-sign certificate: %s", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
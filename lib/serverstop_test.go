@@ -0,0 +1,59 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStopWaitsForHandlers(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	s := &Server{
+		Config:   &ServerConfig{ShutdownTimeout: 10 * time.Millisecond},
+		mux:      http.NewServeMux(),
+		listener: listener,
+		stopped:  make(chan struct{}),
+	}
+	started := make(chan struct{})
+	var returned int32
+	s.mux.Handle("/slow", s.withHandlerTracking(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		atomic.StoreInt32(&returned, 1)
+	})))
+	s.httpServer = &http.Server{Handler: s.mux}
+	go s.httpServer.Serve(listener)
+	go http.Get("http://" + listener.Addr().String() + "/slow")
+	<-started
+
+	// The handler outlasts the shutdown timeout, so its connection is closed,
+	// but the CAs are not closed until it returns
+	err = s.Stop()
+	if err != nil {
+		t.Errorf("Server stop failed: %s", err)
+	}
+	if atomic.LoadInt32(&returned) != 1 {
+		t.Error("The server stopped before the handler of an active request returned")
+	}
+}
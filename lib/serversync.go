@@ -0,0 +1,221 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cloudflare/cfssl/log"
+	"github.com/hyperledger/fabric-ca/lib/ldap"
+	"github.com/hyperledger/fabric-ca/lib/spi"
+)
+
+// SyncReport is the result of syncing the registry of a CA with its config
+type SyncReport struct {
+	// CAName is the name of the CA
+	CAName string
+	// AddedAffiliations are the configured affiliations which were added
+	AddedAffiliations []string
+	// AddedIdentities are the configured identities which were added
+	AddedIdentities []string
+	// UpdatedIdentities are the registered identities which were updated
+	// to match their config
+	UpdatedIdentities []string
+	// Drift describes each difference between a registered identity and its
+	// config which was not updated
+	Drift []string
+}
+
+// Sync adds the configured identities and affiliations of each CA which are
+// not yet in its registry, and either updates the identities which differ
+// from their config or reports the differences, without starting the server.
+// Identities and affiliations which are not in the config are never deleted.
+func (s *Server) Sync() ([]*SyncReport, error) {
+	err := s.initConfig()
+	if err != nil {
+		return nil, err
+	}
+	err = s.loadCAs()
+	if err != nil {
+		return nil, err
+	}
+	reports := []*SyncReport{}
+	for _, ca := range s.getCAs() {
+		err = ca.initDB()
+		if err != nil {
+			return reports, err
+		}
+		report, err := ca.syncRegistry()
+		if err != nil {
+			return reports, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// syncRegistry adds the configured identities and affiliations which are not
+// yet in the registry of the CA, and syncs the identities which are.  The LDAP
+// registry is not changed by the server.
+func (ca *CA) syncRegistry() (*SyncReport, error) {
+	report := &SyncReport{CAName: ca.Config.CA.Name}
+	if _, ok := ca.registry.(*ldap.Client); ok {
+		return report, nil
+	}
+	err := ca.loadAffiliationsTable(report)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to add affiliations of CA '%s': %s", report.CAName, err)
+	}
+	err = ca.loadUsersTable(report)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to add identities of CA '%s': %s", report.CAName, err)
+	}
+	if len(report.AddedAffiliations) > 0 {
+		log.Infof("Added affiliations to CA '%s': %v", report.CAName, report.AddedAffiliations)
+	}
+	if len(report.AddedIdentities) > 0 {
+		log.Infof("Added identities to CA '%s': %v", report.CAName, report.AddedIdentities)
+	}
+	if len(report.UpdatedIdentities) > 0 {
+		log.Infof("Updated identities of CA '%s': %v", report.CAName, report.UpdatedIdentities)
+	}
+	for _, drift := range report.Drift {
+		log.Warningf("CA '%s': %s", report.CAName, drift)
+	}
+	return report, nil
+}
+
+// validateSync checks that the configured identities and affiliations of the
+// CA can be added to its registry, so that a reload is refused before the
+// registry of any CA is changed
+func (ca *CA) validateSync() error {
+	name := ca.Config.CA.Name
+	if _, ok := ca.registry.(*ldap.Client); ok {
+		return nil
+	}
+	err := validateAffiliations(ca.Config.Affiliations)
+	if err != nil {
+		return fmt.Errorf("Invalid affiliations of CA '%s': %s", name, err)
+	}
+	for _, id := range ca.Config.Registry.Identities {
+		if id.ID == "" {
+			return fmt.Errorf("Invalid identity of CA '%s': the name is required", name)
+		}
+		_, err = ca.getMaxEnrollments(id.MaxEnrollments)
+		if err != nil {
+			return fmt.Errorf("Invalid identity '%s' of CA '%s': %s", id.ID, name, err)
+		}
+	}
+	return nil
+}
+
+// validateAffiliations checks that an affiliations section is a tree of
+// names which loadAffiliationsTableR can add to the registry
+func validateAffiliations(val interface{}) error {
+	switch val.(type) {
+	case nil, string, []string:
+		return nil
+	case []interface{}:
+		for _, ele := range val.([]interface{}) {
+			err := validateAffiliations(ele)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		for _, ele := range val.(map[string]interface{}) {
+			err := validateAffiliations(ele)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("'%v' is not an affiliation name, list or map", val)
+	}
+}
+
+// syncIdentity updates the type, affiliation and attributes of a registered
+// identity to match its config if the registry's "updateidentities" is set,
+// and otherwise reports the differences.  Its password is never changed.
+func (ca *CA) syncIdentity(id *ServerConfigIdentity, info *spi.UserInfo, report *SyncReport) error {
+	diffs := identityDiffs(id, info)
+	if len(diffs) == 0 {
+		log.Debugf("Loaded identity: %+v", id)
+		return nil
+	}
+	if !ca.Config.Registry.UpdateIdentities {
+		for _, diff := range diffs {
+			report.Drift = append(report.Drift, fmt.Sprintf("Identity '%s' %s", id.ID, diff))
+		}
+		return nil
+	}
+	info.Type = id.Type
+	info.Group = id.Affiliation
+	info.Attributes = ca.convertAttrs(id.Attributes)
+	err := ca.registry.UpdateUser(*info)
+	if err != nil {
+		return fmt.Errorf("Failed to update user '%s': %s", id.ID, err)
+	}
+	log.Debugf("Updated identity: %+v", id)
+	report.UpdatedIdentities = append(report.UpdatedIdentities, id.ID)
+	return nil
+}
+
+// identityDiffs describes the differences between the type, affiliation and
+// attributes of a registered identity and those of its config
+func identityDiffs(id *ServerConfigIdentity, info *spi.UserInfo) []string {
+	diffs := []string{}
+	if info.Type != id.Type {
+		diffs = append(diffs, fmt.Sprintf("has type '%s' but is configured with type '%s'",
+			info.Type, id.Type))
+	}
+	if info.Group != id.Affiliation {
+		diffs = append(diffs, fmt.Sprintf("has affiliation '%s' but is configured with affiliation '%s'",
+			info.Group, id.Affiliation))
+	}
+	attrs := map[string]string{}
+	for _, attr := range info.Attributes {
+		attrs[attr.Name] = attr.Value
+	}
+	names := []string{}
+	for name := range id.Attributes {
+		names = append(names, name)
+	}
+	for name := range attrs {
+		if _, found := id.Attributes[name]; !found {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, registered := attrs[name]
+		cfgValue, configured := id.Attributes[name]
+		switch {
+		case !registered:
+			diffs = append(diffs, fmt.Sprintf("does not have configured attribute '%s'", name))
+		case !configured:
+			diffs = append(diffs, fmt.Sprintf("has attribute '%s' which is not configured", name))
+		case value != cfgValue:
+			diffs = append(diffs, fmt.Sprintf("has attribute '%s' with value '%s' but it is configured with value '%s'",
+				name, value, cfgValue))
+		}
+	}
+	return diffs
+}
@@ -23,59 +23,46 @@ import (
 	"net/http"
 
 	cfsslapi "github.com/cloudflare/cfssl/api"
-	cerr "github.com/cloudflare/cfssl/errors"
 	"github.com/cloudflare/cfssl/log"
 	"github.com/hyperledger/fabric-ca/api"
-	"github.com/hyperledger/fabric-ca/lib/csp"
 	"github.com/hyperledger/fabric-ca/lib/tcert"
 	"github.com/hyperledger/fabric-ca/util"
 )
 
 // Handler for tcert requests
 type tcertHandler struct {
-	mgr     *tcert.Mgr
-	keyTree *tcert.KeyTree
+	// Returns the CA which issues the TCerts
+	getCA caGetter
 }
 
-// NewTCertHandler is constructor for tcert handler
-func NewTCertHandler() (h http.Handler, err error) {
-	handler, err := initTCertHandler()
-	if err != nil {
-		return nil, fmt.Errorf("Failed to initialize TCert handler: %s", err)
-	}
-	return handler, nil
-}
-
-func initTCertHandler() (h http.Handler, err error) {
-	log.Debug("Initializing TCert handler")
-	mgr, err := tcert.LoadMgr(CAKeyFile, CACertFile)
-	if err != nil {
-		return nil, err
-	}
-	// FIXME: The root prekey must be stored persistently in DB and retrieved here if not found
-	rootKey, err := csp.GenRootKey(MyCSP)
-	if err != nil {
-		return nil, err
-	}
-	keyTree := tcert.NewKeyTree(MyCSP, rootKey)
-	handler := &cfsslapi.HTTPHandler{
-		Handler: &tcertHandler{mgr: mgr, keyTree: keyTree},
-		Methods: []string{"POST"},
-	}
-	return handler, nil
+// newTCertHandler is constructor for tcert handler
+func newTCertHandler(getCA caGetter) (h http.Handler, err error) {
+	return newHTTPHandler(&tcertHandler{getCA: getCA}, "POST"), nil
 }
 
 // Handle a tcert request
 func (h *tcertHandler) Handle(w http.ResponseWriter, r *http.Request) error {
 	err := h.handle(w, r)
-	if err != nil {
-		return cerr.NewBadRequest(err)
+	if err == nil {
+		return nil
 	}
-	return nil
+	if _, ok := err.(*ServerError); ok {
+		return err
+	}
+	return newServerError(ErrCodeBadRequest, "%s", err)
 }
 
 func (h *tcertHandler) handle(w http.ResponseWriter, r *http.Request) error {
 
+	// Get the CA which issues the TCerts
+	ca, err := h.getCA(r)
+	if err != nil {
+		return err
+	}
+	if ca.tcertMgr == nil {
+		return fmt.Errorf("CA '%s' is not able to issue TCerts", ca.Config.CA.Name)
+	}
+
 	// Read and unmarshall the request body
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -95,13 +82,13 @@ func (h *tcertHandler) handle(w http.ResponseWriter, r *http.Request) error {
 
 	// Get the user's attribute values and affiliation path
 	id := tcert.GetEnrollmentIDFromCert(cert)
-	attrs, affiliationPath, err := getUserInfo(id, req.AttrNames)
+	attrs, affiliationPath, err := ca.getUserInfo(id, req.AttrNames)
 	if err != nil {
 		return err
 	}
 
 	// Get the prekey associated with the affiliation path
-	prekey, err := h.keyTree.GetKey(affiliationPath)
+	prekey, err := ca.tcertKeyTree.GetKey(affiliationPath)
 	if err != nil {
 		return fmt.Errorf("Failed to get prekey for user %s: %s", id, err)
 	}
@@ -118,7 +105,7 @@ func (h *tcertHandler) handle(w http.ResponseWriter, r *http.Request) error {
 		ValidityPeriod: req.ValidityPeriod,
 		PreKey:         prekeyStr,
 	}
-	resp, err := h.mgr.GetBatch(tcertReq, cert)
+	resp, err := ca.tcertMgr.GetBatch(tcertReq, cert)
 	if err != nil {
 		return err
 	}
@@ -145,8 +132,8 @@ func getCertFromAuthHdr(r *http.Request) (*x509.Certificate, error) {
 }
 
 // getUserinfo returns the users requested attribute values and user's affiliation path
-func getUserInfo(id string, attrNames []string) ([]tcert.Attribute, []string, error) {
-	user, err := UserRegistry.GetUser(id, attrNames)
+func (ca *CA) getUserInfo(id string, attrNames []string) ([]tcert.Attribute, []string, error) {
+	user, err := ca.registry.GetUser(id, attrNames)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -0,0 +1,300 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/log"
+	"github.com/cloudflare/cfssl/signer"
+	"github.com/cloudflare/cfssl/signer/local"
+	libcsp "github.com/hyperledger/fabric-ca/lib/csp"
+	"github.com/hyperledger/fabric-ca/util"
+)
+
+const (
+	// DefaultTLSCertFile and DefaultTLSKeyFile are the default names of the
+	// server's TLS certificate and key files
+	DefaultTLSCertFile = "tls-cert.pem"
+	DefaultTLSKeyFile  = "tls-key.pem"
+
+	// DefaultTLSCertExpiry is the validity period of a TLS certificate which
+	// the server issues for itself, unless its CA's certificate expires sooner
+	DefaultTLSCertExpiry = 365 * 24 * time.Hour
+
+	// tlsRenewCheckInterval is how often the server checks whether the TLS
+	// certificate which it issued for itself must be renewed
+	tlsRenewCheckInterval = time.Hour
+)
+
+// initTLSCert issues a TLS certificate for the server from its default CA
+// if TLS is enabled and the TLS certificate file does not exist
+func (s *Server) initTLSCert() error {
+	cfg := &s.Config.TLS
+	if !cfg.Enabled || util.FileExists(cfg.CertFile) {
+		return nil
+	}
+	log.Infof("The TLS certificate file %s does not exist; issuing a TLS certificate from CA '%s'",
+		cfg.CertFile, s.CA.Config.CA.Name)
+	return s.issueTLSCert()
+}
+
+// issueTLSCert issues a TLS certificate for the hosts of the default CA's
+// CSR config from the default CA.  Its key is generated in the CA's CSP, so
+// only a reference to the key by its SKI is stored in the TLS key file.  The
+// certificate file of an intermediate CA also holds the CA's chain.
+func (s *Server) issueTLSCert() error {
+	ca := &s.CA
+	if ca.Config.Remote != "" {
+		return errors.New("The TLS certificate cannot be issued by a CA which signs remotely")
+	}
+	hosts := ca.Config.CSR.Hosts
+	if len(hosts) == 0 {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("Failed to get the hostname for the TLS certificate: %s", err)
+		}
+		hosts = []string{hostname, "localhost"}
+	}
+	req := &csr.CertificateRequest{
+		CN:         hosts[0],
+		Names:      ca.Config.CSR.Names,
+		Hosts:      hosts,
+		KeyRequest: csr.NewBasicKeyRequest(),
+	}
+	key, tlsSigner, err := libcsp.GenKey(ca.csp, req.KeyRequest)
+	if err != nil {
+		return fmt.Errorf("Failed to generate TLS key: %s", err)
+	}
+	csrPEM, err := csr.Generate(tlsSigner, req)
+	if err != nil {
+		return fmt.Errorf("Failed to generate TLS certificate request: %s", err)
+	}
+
+	// Sign with the CA's key, using a profile for TLS servers
+	expiry := DefaultTLSCertExpiry
+	if remaining := time.Until(ca.cert.NotAfter); remaining < expiry {
+		expiry = remaining
+	}
+	policy := &config.Signing{
+		Profiles: map[string]*config.SigningProfile{},
+		Default: &config.SigningProfile{
+			Usage:        []string{"digital signature", "key encipherment", "server auth"},
+			Expiry:       expiry,
+			ExpiryString: expiry.String(),
+		},
+	}
+	caSigner, err := libcsp.GetSignerFromSKIFile(ca.Config.CA.Keyfile, ca.csp)
+	if err != nil {
+		return fmt.Errorf("Failed to get the CA's signer: %s", err)
+	}
+	tlsCertSigner, err := local.NewSigner(caSigner, ca.cert, signer.DefaultSigAlgo(caSigner), policy)
+	if err != nil {
+		return err
+	}
+	tlsCertSigner.SetDBAccessor(ca.certDBAccessor)
+	cert, err := tlsCertSigner.Sign(signer.SignRequest{Request: string(csrPEM), Hosts: hosts})
+	if err != nil {
+		return fmt.Errorf("Failed to sign TLS certificate: %s", err)
+	}
+
+	// Clients of an intermediate CA may only trust the root CA, so the
+	// CA's chain is sent after the TLS certificate
+	if ca.Config.Intermediate.ParentServer.URL != "" {
+		chain, err := ioutil.ReadFile(ca.Config.CA.Chainfile)
+		if err != nil {
+			return fmt.Errorf("Failed to read certificate chain: %s", err)
+		}
+		cert = append(cert, chain...)
+	}
+
+	// Both files are written to temporary files, which are then renamed
+	// over them, the key file first, so that the listener never loads a
+	// partially written file and a failure never leaves a new certificate
+	// with the previous key
+	cfg := &s.Config.TLS
+	err = storeTLSFiles(cfg.KeyFile, libcsp.SKIToPEM(key), cfg.CertFile, cert)
+	if err != nil {
+		return err
+	}
+	log.Infof("Issued TLS certificate for %v, which expires in %s", hosts, expiry)
+	log.Infof("TLS key file location: %s", cfg.KeyFile)
+	log.Infof("TLS certificate file location: %s", cfg.CertFile)
+	return nil
+}
+
+// Store the key and certificate files of an issued TLS certificate.  If the
+// certificate file cannot be replaced, the previous key file is restored.
+func storeTLSFiles(keyFile string, key []byte, certFile string, cert []byte) error {
+	for _, file := range []string{certFile, keyFile} {
+		err := os.MkdirAll(filepath.Dir(file), 0755)
+		if err != nil {
+			return fmt.Errorf("Failed to create directory of %s: %s", file, err)
+		}
+	}
+	keyTmp, err := util.WriteTempFile(keyFile, key, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to store TLS key: %s", err)
+	}
+	defer os.Remove(keyTmp)
+	certTmp, err := util.WriteTempFile(certFile, cert, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to store TLS certificate: %s", err)
+	}
+	defer os.Remove(certTmp)
+	prevKey, prevKeyErr := ioutil.ReadFile(keyFile)
+	err = os.Rename(keyTmp, keyFile)
+	if err != nil {
+		return fmt.Errorf("Failed to store TLS key: %s", err)
+	}
+	err = os.Rename(certTmp, certFile)
+	if err != nil {
+		if prevKeyErr == nil {
+			rerr := util.WriteFileAtomically(keyFile, prevKey, 0600)
+			if rerr != nil {
+				log.Errorf("Failed to restore the previous TLS key file %s: %s", keyFile, rerr)
+			}
+		} else {
+			os.Remove(keyFile)
+		}
+		return fmt.Errorf("Failed to store TLS certificate: %s", err)
+	}
+	return nil
+}
+
+// loadTLSCert loads the server's TLS certificate.  The key file holds either
+// a PEM-encoded key, or a reference to a key in the default CA's CSP such as
+// that of a TLS certificate which the server issued for itself.
+func (s *Server) loadTLSCert(certFile, keyFile string) (*tls.Certificate, error) {
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read TLS key: %s", err)
+	}
+	if !libcsp.IsSKIFile(keyPEM) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &cert, nil
+	}
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read TLS certificate: %s", err)
+	}
+	cert := &tls.Certificate{}
+	for {
+		var block *pem.Block
+		block, certPEM = pem.Decode(certPEM)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, fmt.Errorf("No certificate found in TLS certificate file %s", certFile)
+	}
+	cert.PrivateKey, err = libcsp.GetSignerFromSKIFile(keyFile, s.CA.csp)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get TLS key: %s", err)
+	}
+	return cert, nil
+}
+
+// Start renewing the TLS certificate which the server issued for itself in
+// the background, if TLS is enabled
+func (s *Server) startTLSRenewer() {
+	if !s.Config.TLS.Enabled {
+		return
+	}
+	stop := make(chan struct{})
+	s.tlsRenewerStop = stop
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		ticker := time.NewTicker(tlsRenewCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := s.renewTLSCert()
+				if err != nil {
+					log.Errorf("Failed to renew TLS certificate: %s", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop renewing the TLS certificate in the background.  A renewal which is
+// in progress completes; wait for s.background to wait for it.
+func (s *Server) stopTLSRenewer() {
+	if s.tlsRenewerStop != nil {
+		close(s.tlsRenewerStop)
+		s.tlsRenewerStop = nil
+	}
+}
+
+// renewTLSCert renews the TLS certificate which the server issued for itself
+// once less than a third of its validity period remains, and replaces the
+// certificate of the listener with it.  TLS certificates which were not
+// issued by the server are not renewed.
+func (s *Server) renewTLSCert() error {
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+	current, ok := s.tlsCert.Load().(*tls.Certificate)
+	if !ok {
+		return nil
+	}
+	leaf, err := x509.ParseCertificate(current.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("Failed to parse TLS certificate: %s", err)
+	}
+	if time.Until(leaf.NotAfter) > leaf.NotAfter.Sub(leaf.NotBefore)/3 {
+		return nil
+	}
+	cfg := &s.Config.TLS
+	keyPEM, err := ioutil.ReadFile(cfg.KeyFile)
+	if err != nil || !libcsp.IsSKIFile(keyPEM) || s.CA.checkIssuer(leaf) != nil {
+		log.Debugf("The TLS certificate in %s was not issued by the server, which does not renew it", cfg.CertFile)
+		return nil
+	}
+	log.Infof("Renewing the TLS certificate, which expires at %s", leaf.NotAfter)
+	err = s.issueTLSCert()
+	if err != nil {
+		return err
+	}
+	cert, err := s.loadTLSCert(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	s.tlsCert.Store(cert)
+	return nil
+}
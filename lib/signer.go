@@ -19,9 +19,10 @@ package lib
 import (
 	"github.com/cloudflare/cfssl/log"
 	"github.com/hyperledger/fabric-ca/api"
+	"github.com/hyperledger/fabric/bccsp"
 )
 
-func newSigner(key, cert []byte, id *Identity) *Signer {
+func newSigner(key bccsp.Key, cert []byte, id *Identity) *Signer {
 	return &Signer{
 		key:    key,
 		cert:   cert,
@@ -33,8 +34,9 @@ func newSigner(key, cert []byte, id *Identity) *Signer {
 // Signer represents a signer
 // Each identity may have multiple signers, currently one ecert and multiple tcerts
 type Signer struct {
-	name   string
-	key    []byte
+	name string
+	// The signer's key, which is held by the client's BCCSP
+	key    bccsp.Key
 	cert   []byte
 	id     *Identity
 	client *Client
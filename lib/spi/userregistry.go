@@ -43,6 +43,8 @@ type GroupInfo struct {
 type User interface {
 	// Returns the enrollment ID of the user
 	GetName() string
+	// Returns the type of the user
+	GetType() string
 	// Login the user with a password
 	Login(password string) error
 	// Get the complete path for the user's affiliation.
@@ -62,6 +64,7 @@ type Group interface {
 type UserRegistry interface {
 	GetUser(id string, attrs []string) (User, error)
 	GetUserInfo(id string) (UserInfo, error)
+	GetGroupUsers(name string) ([]UserInfo, error)
 	InsertUser(user UserInfo) error
 	UpdateUser(user UserInfo) error
 	DeleteUser(id string) error
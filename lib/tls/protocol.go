@@ -0,0 +1,178 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"github.com/cloudflare/cfssl/log"
+)
+
+// ProtocolConfig defines the TLS versions, cipher suites and curves which a
+// TLS server or client accepts.  Go's defaults apply to those not set.
+type ProtocolConfig struct {
+	// Preset, if "fips", selects TLS 1.2 with the FIPS approved ECDHE AES-GCM
+	// cipher suites and NIST curves; the other settings override the preset
+	Preset string `json:"preset,omitempty"`
+	// MinVersion and MaxVersion are each one of "tls1.0", "tls1.1",
+	// "tls1.2" and "tls1.3"
+	MinVersion string `json:"minversion,omitempty"`
+	MaxVersion string `json:"maxversion,omitempty"`
+	// CipherSuites are the names of the cipher suites of TLS 1.2 and below,
+	// such as "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"; the cipher suites
+	// of TLS 1.3 are not configurable
+	CipherSuites []string `json:"ciphersuites,omitempty"`
+	// Curves are the names of the elliptic curves, which are "P-256",
+	// "P-384", "P-521" and "X25519"
+	Curves []string `json:"curves,omitempty"`
+}
+
+// IsSet returns true if any of the protocol settings are set
+func (p *ProtocolConfig) IsSet() bool {
+	return p.Preset != "" || p.MinVersion != "" || p.MaxVersion != "" ||
+		len(p.CipherSuites) > 0 || len(p.Curves) > 0
+}
+
+// tlsVersions maps the names of the TLS versions to those of crypto/tls
+var tlsVersions = map[string]uint16{
+	"tls1.0": tls.VersionTLS10,
+	"tls1.1": tls.VersionTLS11,
+	"tls1.2": tls.VersionTLS12,
+	"tls1.3": tls.VersionTLS13,
+}
+
+// curves maps the names of the curves to those of crypto/tls
+var curves = map[string]tls.CurveID{
+	"P-256":  tls.CurveP256,
+	"P-384":  tls.CurveP384,
+	"P-521":  tls.CurveP521,
+	"X25519": tls.X25519,
+}
+
+// fipsPreset is the "fips" preset
+var fipsPreset = ProtocolConfig{
+	MinVersion: "tls1.2",
+	MaxVersion: "tls1.2",
+	CipherSuites: []string{
+		"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+		"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+		"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+		"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	},
+	Curves: []string{"P-256", "P-384", "P-521"},
+}
+
+// SetProtocol validates the protocol settings and sets them in a TLS config
+func SetProtocol(config *tls.Config, cfg *ProtocolConfig) error {
+	p := *cfg
+	switch strings.ToLower(p.Preset) {
+	case "":
+	case "fips":
+		if p.MinVersion == "" {
+			p.MinVersion = fipsPreset.MinVersion
+		}
+		if p.MaxVersion == "" {
+			p.MaxVersion = fipsPreset.MaxVersion
+		}
+		if len(p.CipherSuites) == 0 {
+			p.CipherSuites = fipsPreset.CipherSuites
+		}
+		if len(p.Curves) == 0 {
+			p.Curves = fipsPreset.Curves
+		}
+	default:
+		return fmt.Errorf("Invalid TLS preset '%s'; must be 'fips'", p.Preset)
+	}
+	minVersion, err := getTLSVersion(p.MinVersion)
+	if err != nil {
+		return err
+	}
+	maxVersion, err := getTLSVersion(p.MaxVersion)
+	if err != nil {
+		return err
+	}
+	if minVersion != 0 && maxVersion != 0 && minVersion > maxVersion {
+		return fmt.Errorf("TLS minimum version '%s' is greater than maximum version '%s'",
+			p.MinVersion, p.MaxVersion)
+	}
+	suites, err := getCipherSuites(p.CipherSuites)
+	if err != nil {
+		return err
+	}
+	curveIDs := []tls.CurveID{}
+	for _, name := range p.Curves {
+		id, found := curves[name]
+		if !found {
+			return fmt.Errorf("Invalid TLS curve '%s'", name)
+		}
+		curveIDs = append(curveIDs, id)
+	}
+	config.MinVersion = minVersion
+	config.MaxVersion = maxVersion
+	if len(suites) > 0 {
+		config.CipherSuites = suites
+	}
+	if len(curveIDs) > 0 {
+		config.CurvePreferences = curveIDs
+	}
+	return nil
+}
+
+// getTLSVersion returns the TLS version of a name, or 0 if none
+func getTLSVersion(name string) (uint16, error) {
+	if name == "" {
+		return 0, nil
+	}
+	version, found := tlsVersions[strings.ToLower(name)]
+	if !found {
+		return 0, fmt.Errorf("Invalid TLS version '%s'; must be one of 'tls1.0', 'tls1.1', 'tls1.2' and 'tls1.3'", name)
+	}
+	return version, nil
+}
+
+// getCipherSuites returns the IDs of the named cipher suites.  Those which
+// are insecure are allowed, but a warning is logged for each.
+func getCipherSuites(names []string) ([]uint16, error) {
+	ids := []uint16{}
+	for _, name := range names {
+		suite := findCipherSuite(name, tls.CipherSuites())
+		if suite == nil {
+			suite = findCipherSuite(name, tls.InsecureCipherSuites())
+			if suite == nil {
+				return nil, fmt.Errorf("Invalid TLS cipher suite '%s'", name)
+			}
+			log.Warningf("TLS cipher suite '%s' is insecure", name)
+		}
+		if len(suite.SupportedVersions) == 1 && suite.SupportedVersions[0] == tls.VersionTLS13 {
+			return nil, fmt.Errorf("TLS cipher suite '%s' is a TLS 1.3 cipher suite, which is not configurable", name)
+		}
+		ids = append(ids, suite.ID)
+	}
+	return ids, nil
+}
+
+// findCipherSuite returns the cipher suite with a name, or nil if not found
+func findCipherSuite(name string, suites []*tls.CipherSuite) *tls.CipherSuite {
+	for _, suite := range suites {
+		if suite.Name == strings.ToUpper(name) {
+			return suite
+		}
+	}
+	return nil
+}
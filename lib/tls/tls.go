@@ -19,17 +19,115 @@ package tls
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"regexp"
+	"strings"
 
 	"github.com/cloudflare/cfssl/log"
 )
 
 // ServerTLSConfig defines key material for a TLS server
 type ServerTLSConfig struct {
-	Enabled  bool   `json:"enabled,omitempty"`
-	KeyFile  string `json:"keyfile"`
-	CertFile string `json:"certfile"`
+	Enabled    bool       `json:"enabled,omitempty"`
+	KeyFile    string     `json:"keyfile"`
+	CertFile   string     `json:"certfile"`
+	ClientAuth ClientAuth `json:"clientauth"`
+	// The TLS versions, cipher suites and curves of the server
+	ProtocolConfig `mapstructure:",squash"`
+}
+
+// ClientAuth defines how a TLS server authenticates its clients
+type ClientAuth struct {
+	// Type is one of "noclientcert" (the default), "requestclientcert",
+	// "requireanyclientcert", "verifyclientcertifgiven" or
+	// "requireandverifyclientcert"
+	Type string `json:"type"`
+	// CertFiles are the CA certificates which verify the client certificates
+	CertFiles []string `json:"certfiles"`
+	// CN, if set, is a regular expression which the common name of a client
+	// certificate must match; clients without a certificate are refused
+	CN string `json:"cn"`
+	// OU, if set, is a regular expression which an organizational unit of a
+	// client certificate must match; clients without a certificate are refused
+	OU string `json:"ou"`
+	// MatchToken, if true, requires that the client certificate of a request
+	// authenticated by a token belongs to the same identity as the token
+	MatchToken bool `json:"matchtoken"`
+}
+
+// clientAuthTypes maps the client authentication types to those of crypto/tls
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"noclientcert":               tls.NoClientCert,
+	"requestclientcert":          tls.RequestClientCert,
+	"requireanyclientcert":       tls.RequireAnyClientCert,
+	"verifyclientcertifgiven":    tls.VerifyClientCertIfGiven,
+	"requireandverifyclientcert": tls.RequireAndVerifyClientCert,
+}
+
+// SetClientAuth sets the client authentication of a TLS server's config
+func SetClientAuth(config *tls.Config, cfg *ClientAuth) error {
+	authType := tls.NoClientCert
+	if cfg.Type != "" {
+		var found bool
+		authType, found = clientAuthTypes[strings.ToLower(cfg.Type)]
+		if !found {
+			return fmt.Errorf("Invalid TLS client authentication type '%s'", cfg.Type)
+		}
+	}
+	config.ClientAuth = authType
+	// The CN and OU of, or the identity in, a certificate which is not
+	// verified prove nothing
+	if (cfg.CN != "" || cfg.OU != "" || cfg.MatchToken) && authType < tls.VerifyClientCertIfGiven {
+		return fmt.Errorf("The TLS client certificate CN, OU and matchtoken settings require the "+
+			"client authentication type 'verifyclientcertifgiven' or 'requireandverifyclientcert', not '%s'", cfg.Type)
+	}
+	if authType >= tls.VerifyClientCertIfGiven {
+		if len(cfg.CertFiles) == 0 {
+			return fmt.Errorf("TLS client authentication type '%s' requires CA certificate files", cfg.Type)
+		}
+		pool, err := loadCertPool(cfg.CertFiles)
+		if err != nil {
+			return err
+		}
+		config.ClientCAs = pool
+	}
+	if cfg.CN == "" && cfg.OU == "" {
+		return nil
+	}
+	cnRegexp, err := regexp.Compile(cfg.CN)
+	if err != nil {
+		return fmt.Errorf("Invalid TLS client certificate CN regular expression: %s", err)
+	}
+	ouRegexp, err := regexp.Compile(cfg.OU)
+	if err != nil {
+		return fmt.Errorf("Invalid TLS client certificate OU regular expression: %s", err)
+	}
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			// Even with the verifyclientcertifgiven type, only clients
+			// whose certificate matches may connect
+			return errors.New("A TLS client certificate is required by the CN and OU settings")
+		}
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return fmt.Errorf("Failed to parse TLS client certificate: %s", err)
+		}
+		if !cnRegexp.MatchString(cert.Subject.CommonName) {
+			return fmt.Errorf("TLS client certificate CN '%s' is not allowed", cert.Subject.CommonName)
+		}
+		if cfg.OU == "" {
+			return nil
+		}
+		for _, ou := range cert.Subject.OrganizationalUnit {
+			if ouRegexp.MatchString(ou) {
+				return nil
+			}
+		}
+		return fmt.Errorf("TLS client certificate OU %v is not allowed", cert.Subject.OrganizationalUnit)
+	}
+	return nil
 }
 
 // ClientTLSConfig defines the key material for a TLS client
@@ -37,6 +135,14 @@ type ClientTLSConfig struct {
 	Enabled   bool         `json:"enabled,omitempty"`
 	CertFiles []string     `json:"certfiles"`
 	Client    KeyCertFiles `json:"client"`
+	// ServerName, if set, overrides the server name which is sent in the
+	// TLS handshake (SNI) and verified against the server's certificate
+	ServerName string `json:"servername,omitempty"`
+	// InsecureSkipVerify, if true, does not verify the server's certificate,
+	// which is only safe for development
+	InsecureSkipVerify bool `json:"insecureskipverify,omitempty"`
+	// The TLS versions, cipher suites and curves of the client
+	ProtocolConfig `mapstructure:",squash"`
 }
 
 // KeyCertFiles defines the files need for client on TLS
@@ -45,44 +151,91 @@ type KeyCertFiles struct {
 	CertFile string `json:"certfile"`
 }
 
-// GetClientTLSConfig creates a tls.Config object from certs and roots
+// EnableIfConfigured handles a config in which TLS is not enabled but CA
+// certificate files or a client certificate are configured.  Earlier
+// releases used TLS whenever the URL required it, with these files, and
+// their config file templates set them while TLS is disabled.  If 'secure'
+// is true, because the URL of the server named 'server' requires TLS, TLS
+// is therefore enabled; otherwise, the files are ignored.  Either is logged
+// as a warning.
+func (cfg *ClientTLSConfig) EnableIfConfigured(secure bool, server string) {
+	if cfg.Enabled {
+		return
+	}
+	if len(cfg.CertFiles) == 0 && cfg.Client.CertFile == "" && cfg.Client.KeyFile == "" {
+		return
+	}
+	if secure {
+		log.Warningf("Enabling TLS to the %s since TLS certificate files are configured for its secure URL; set tls.enabled to true", server)
+		cfg.Enabled = true
+		return
+	}
+	log.Warningf("Ignoring the TLS certificate files of the %s since TLS is not enabled and its URL is not secure", server)
+	cfg.CertFiles = nil
+	cfg.Client = KeyCertFiles{}
+}
+
+// GetClientTLSConfig creates a tls.Config object from certs and roots.  It
+// returns nil if TLS is not enabled.  The CA cert files are required unless
+// the server's certificate is not verified, and the client's cert and key
+// files are optional but must be set together.
 func GetClientTLSConfig(cfg *ClientTLSConfig) (*tls.Config, error) {
-	//if !cfg.Enabled {
-	//	return nil, nil
-	//}
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	log.Debugf("CA Files: %s", cfg.CertFiles)
+	log.Debugf("Client Cert File: %s", cfg.Client.CertFile)
+	log.Debugf("Client Key File: %s", cfg.Client.KeyFile)
 	var certs []tls.Certificate
+	if cfg.Client.CertFile != "" || cfg.Client.KeyFile != "" {
+		if cfg.Client.CertFile == "" || cfg.Client.KeyFile == "" {
+			return nil, errors.New("TLS client certificate and key files must be set together")
+		}
+		clientCert, err := tls.LoadX509KeyPair(cfg.Client.CertFile, cfg.Client.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load TLS client certificate and key: %s", err)
+		}
+		certs = append(certs, clientCert)
+	}
 
-	log.Debugf("CA Files: %s\n", cfg.CertFiles)
-	log.Debugf("Client Cert File: %s\n", cfg.Client.CertFile)
-	log.Debugf("Client Key File: %s\n", cfg.Client.KeyFile)
-	clientCert, err := tls.LoadX509KeyPair(cfg.Client.CertFile, cfg.Client.KeyFile)
+	config := &tls.Config{
+		Certificates:       certs,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.InsecureSkipVerify {
+		log.Warning("TLS server certificates are not verified; this is insecure")
+	} else if len(cfg.CertFiles) == 0 {
+		return nil, errors.New("TLS is enabled, but no CA certificate files are configured to verify the server")
+	}
+	if len(cfg.CertFiles) > 0 {
+		rootCAPool, err := loadCertPool(cfg.CertFiles)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = rootCAPool
+	}
+	err := SetProtocol(config, &cfg.ProtocolConfig)
 	if err != nil {
-		log.Debugf("Client Cert or Key not provided, if server requires mutual TLS, the connection will fail [error: %s]", err)
+		return nil, err
 	}
 
-	certs = append(certs, clientCert)
-
-	rootCAPool := x509.NewCertPool()
-
-	if len(cfg.CertFiles) == 0 {
-		log.Debug("No CA cert files provided. If server requires TLS, connection will fail")
-	}
+	return config, nil
+}
 
-	for _, cacert := range cfg.CertFiles {
+// loadCertPool returns a pool of the certificates in PEM files
+func loadCertPool(certFiles []string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, cacert := range certFiles {
 		caCert, err := ioutil.ReadFile(cacert)
 		if err != nil {
 			return nil, err
 		}
-		ok := rootCAPool.AppendCertsFromPEM(caCert)
+		ok := pool.AppendCertsFromPEM(caCert)
 		if !ok {
 			return nil, fmt.Errorf("Failed to process certificate from file %s", cacert)
 		}
 	}
-
-	config := &tls.Config{
-		Certificates: certs,
-		RootCAs:      rootCAPool,
-	}
-
-	return config, nil
+	return pool, nil
 }
@@ -17,7 +17,9 @@ limitations under the License.
 package tls
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"encoding/pem"
 	"io/ioutil"
 	"testing"
 )
@@ -33,9 +35,210 @@ func TestGetClientTLSConfig(t *testing.T) {
 	var cfg = new(ClientTLSConfig)
 	json.Unmarshal(tlsConfig, cfg)
 
-	_, err = GetClientTLSConfig(cfg)
+	// No TLS config is returned unless TLS is enabled
+	config, err := GetClientTLSConfig(cfg)
+	if err != nil || config != nil {
+		t.Errorf("TLS config should not have been returned when TLS is disabled: %+v, %v", config, err)
+	}
+
+	cfg.Enabled = true
+	config, err = GetClientTLSConfig(cfg)
 	if err != nil {
-		t.Errorf("Failed to get TLS Config [error: %s]", err)
+		t.Fatalf("Failed to get TLS Config [error: %s]", err)
+	}
+	if config.RootCAs == nil || len(config.Certificates) != 1 {
+		t.Errorf("TLS config does not have the CA and client certificates: %+v", config)
+	}
+
+	// Misconfigurations fail
+	noCerts := *cfg
+	noCerts.CertFiles = nil
+	_, err = GetClientTLSConfig(&noCerts)
+	if err == nil {
+		t.Error("TLS config without CA certificate files should have failed")
+	}
+	noKey := *cfg
+	noKey.Client.KeyFile = ""
+	_, err = GetClientTLSConfig(&noKey)
+	if err == nil {
+		t.Error("TLS config with a client certificate but no key should have failed")
+	}
+	badKey := *cfg
+	badKey.Client.KeyFile = "../../testdata/bogus-key.pem"
+	_, err = GetClientTLSConfig(&badKey)
+	if err == nil {
+		t.Error("TLS config with a missing client key file should have failed")
+	}
+
+	// The server's certificate need not be verified, and the server name
+	// may be overridden
+	insecure := ClientTLSConfig{Enabled: true, InsecureSkipVerify: true, ServerName: "ca.example.com"}
+	config, err = GetClientTLSConfig(&insecure)
+	if err != nil {
+		t.Fatalf("Failed to get insecure TLS config: %s", err)
+	}
+	if !config.InsecureSkipVerify || config.ServerName != "ca.example.com" || len(config.Certificates) != 0 {
+		t.Errorf("Insecure TLS config was not set: %+v", config)
+	}
+}
+
+func TestSetClientAuth(t *testing.T) {
+	config := &tls.Config{}
+	err := SetClientAuth(config, &ClientAuth{Type: "bogus"})
+	if err == nil {
+		t.Error("An invalid client authentication type should have failed")
+	}
+	err = SetClientAuth(config, &ClientAuth{Type: "RequireAndVerifyClientCert"})
+	if err == nil {
+		t.Error("Verifying client certificates without CA certificate files should have failed")
+	}
+	err = SetClientAuth(config, &ClientAuth{Type: "requireandverifyclientcert", CN: "("})
+	if err == nil {
+		t.Error("An invalid CN regular expression should have failed")
+	}
+	for _, authType := range []string{"", "requestclientcert", "requireanyclientcert"} {
+		for _, cfg := range []*ClientAuth{
+			{Type: authType, CN: "^localhost$"},
+			{Type: authType, OU: "^COP$"},
+			{Type: authType, MatchToken: true},
+		} {
+			err = SetClientAuth(config, cfg)
+			if err == nil {
+				t.Errorf("%+v should have failed since client certificates are not verified", cfg)
+			}
+		}
 	}
 
+	certPEM, err := ioutil.ReadFile("../../testdata/tls_client-cert.pem")
+	if err != nil {
+		t.Fatalf("Failed to read TLS client certificate: %s", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		t.Fatal("Failed to decode TLS client certificate")
+	}
+	rawCerts := [][]byte{block.Bytes}
+	for _, test := range []struct {
+		cn, ou  string
+		allowed bool
+	}{
+		{"^localhost$", "", true},
+		{"", "^COP$", true},
+		{"^peer", "", false},
+		{"", "^WWW$", false},
+	} {
+		config = &tls.Config{}
+		err = SetClientAuth(config, &ClientAuth{
+			Type:      "requireandverifyclientcert",
+			CertFiles: []string{"../../testdata/root.pem"},
+			CN:        test.cn,
+			OU:        test.ou,
+		})
+		if err != nil {
+			t.Fatalf("Failed to set client authentication: %s", err)
+		}
+		if config.ClientAuth != tls.RequireAndVerifyClientCert || config.ClientCAs == nil {
+			t.Errorf("Client authentication was not set: %+v", config)
+		}
+		if config.VerifyPeerCertificate(nil, nil) == nil {
+			t.Errorf("A client without a certificate should not have been allowed by CN '%s' and OU '%s'", test.cn, test.ou)
+		}
+		err = config.VerifyPeerCertificate(rawCerts, nil)
+		if test.allowed && err != nil {
+			t.Errorf("Certificate should have been allowed by CN '%s' and OU '%s': %s", test.cn, test.ou, err)
+		} else if !test.allowed && err == nil {
+			t.Errorf("Certificate should not have been allowed by CN '%s' and OU '%s'", test.cn, test.ou)
+		}
+	}
+}
+
+func TestEnableIfConfigured(t *testing.T) {
+	// Configs without TLS files, or with TLS enabled, are left unchanged
+	for _, cfg := range []*ClientTLSConfig{
+		{Enabled: true, CertFiles: []string{"root.pem"}},
+		{},
+	} {
+		enabled := cfg.Enabled
+		cfg.EnableIfConfigured(false, "server")
+		if cfg.Enabled != enabled || (enabled && len(cfg.CertFiles) != 1) {
+			t.Errorf("%+v should not have been changed", cfg)
+		}
+	}
+	// TLS files enable TLS for a secure URL
+	for _, cfg := range []*ClientTLSConfig{
+		{CertFiles: []string{"root.pem"}},
+		{Client: KeyCertFiles{CertFile: "cert.pem", KeyFile: "key.pem"}},
+	} {
+		cfg.EnableIfConfigured(true, "server")
+		if !cfg.Enabled {
+			t.Errorf("%+v should have enabled TLS for a secure URL", cfg)
+		}
+	}
+	// and are ignored otherwise
+	for _, cfg := range []*ClientTLSConfig{
+		{CertFiles: []string{"root.pem"}},
+		{Client: KeyCertFiles{CertFile: "cert.pem", KeyFile: "key.pem"}},
+	} {
+		cfg.EnableIfConfigured(false, "server")
+		if cfg.Enabled || len(cfg.CertFiles) != 0 || cfg.Client.CertFile != "" || cfg.Client.KeyFile != "" {
+			t.Errorf("%+v should have ignored the TLS files for an insecure URL", cfg)
+		}
+	}
+}
+
+func TestSetProtocol(t *testing.T) {
+	config := &tls.Config{}
+	err := SetProtocol(config, &ProtocolConfig{Preset: "fips"})
+	if err != nil {
+		t.Fatalf("Failed to set the fips preset: %s", err)
+	}
+	if config.MinVersion != tls.VersionTLS12 || config.MaxVersion != tls.VersionTLS12 ||
+		len(config.CipherSuites) != 4 || len(config.CurvePreferences) != 3 {
+		t.Errorf("The fips preset was not set: %+v", config)
+	}
+
+	// The other settings override the preset
+	config = &tls.Config{}
+	err = SetProtocol(config, &ProtocolConfig{
+		Preset:       "FIPS",
+		MaxVersion:   "tls1.3",
+		CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+		Curves:       []string{"P-384"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to override the fips preset: %s", err)
+	}
+	if config.MinVersion != tls.VersionTLS12 || config.MaxVersion != tls.VersionTLS13 ||
+		len(config.CipherSuites) != 1 || config.CipherSuites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384 ||
+		len(config.CurvePreferences) != 1 || config.CurvePreferences[0] != tls.CurveP384 {
+		t.Errorf("The fips preset was not overridden: %+v", config)
+	}
+
+	for _, cfg := range []ProtocolConfig{
+		{Preset: "bogus"},
+		{MinVersion: "ssl3"},
+		{MinVersion: "tls1.3", MaxVersion: "tls1.2"},
+		{CipherSuites: []string{"TLS_BOGUS"}},
+		{CipherSuites: []string{"TLS_AES_128_GCM_SHA256"}},
+		{Curves: []string{"P-224"}},
+	} {
+		if SetProtocol(&tls.Config{}, &cfg) == nil {
+			t.Errorf("Invalid protocol config should have failed: %+v", cfg)
+		}
+	}
+
+	// The settings are read from a client config
+	var cfg ClientTLSConfig
+	err = json.Unmarshal([]byte(`{"enabled":true,"certfiles":["../../testdata/root.pem"],`+
+		`"minversion":"tls1.2","curves":["X25519"]}`), &cfg)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal client TLS config: %s", err)
+	}
+	config, err = GetClientTLSConfig(&cfg)
+	if err != nil {
+		t.Fatalf("Failed to get client TLS config: %s", err)
+	}
+	if config.MinVersion != tls.VersionTLS12 || len(config.CurvePreferences) != 1 {
+		t.Errorf("Client TLS config does not have the protocol settings: %+v", config)
+	}
 }
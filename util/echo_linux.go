@@ -0,0 +1,51 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// disableEcho turns off the echo of the terminal fd, if it is one, and
+// returns the function which restores it
+func disableEcho(f *os.File) (func(), error) {
+	fd := f.Fd()
+	var saved syscall.Termios
+	if ioctlTermios(fd, syscall.TCGETS, &saved) != nil {
+		// Not a terminal
+		return func() {}, nil
+	}
+	termios := saved
+	termios.Lflag &^= syscall.ECHO
+	if ioctlTermios(fd, syscall.TCSETS, &termios) != nil {
+		return nil, errors.New("Failed to disable the echo of the terminal")
+	}
+	return func() {
+		ioctlTermios(fd, syscall.TCSETS, &saved)
+	}, nil
+}
+
+func ioctlTermios(fd, req uintptr, termios *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(termios)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	"os"
+)
+
+// disableEcho can not turn off the echo of a terminal on this platform, so
+// it refuses to read a password from one.  A password piped to standard
+// input is not echoed and may be read.
+func disableEcho(f *os.File) (func(), error) {
+	info, err := f.Stat()
+	if err == nil && info.Mode()&os.ModeCharDevice == 0 {
+		return func() {}, nil
+	}
+	return nil, errors.New("Reading a password from a terminal is not supported on this platform; pipe the password to standard input or use the environment variable instead")
+}
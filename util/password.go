@@ -0,0 +1,43 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ReadPassword writes a prompt to standard error and reads a password from a
+// line of standard input.  The password is never echoed: if standard input
+// is a terminal whose echo can not be disabled, no password is read.
+func ReadPassword(prompt string) ([]byte, error) {
+	restoreEcho, err := disableEcho(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	restoreEcho()
+	fmt.Fprintln(os.Stderr)
+	if err != nil && (err != io.EOF || line == "") {
+		return nil, fmt.Errorf("Failed to read password: %s", err)
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
@@ -101,6 +101,42 @@ func WriteFile(file string, buf []byte, perm os.FileMode) error {
 	return ioutil.WriteFile(file, buf, perm)
 }
 
+// WriteFileAtomically writes a file by renaming a temporary file in the same
+// directory, so that readers see either the previous or the new contents and
+// a failure never leaves a partially written file
+func WriteFileAtomically(file string, buf []byte, perm os.FileMode) error {
+	tmp, err := WriteTempFile(file, buf, perm)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, file)
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// WriteTempFile writes a temporary file in the directory of 'file' and
+// returns its name; the caller renames it to 'file' or removes it
+func WriteTempFile(file string, buf []byte, perm os.FileMode) (string, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(buf)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
 // FileExists checks to see if a file exists
 func FileExists(name string) bool {
 	if _, err := os.Stat(name); err != nil {
@@ -216,6 +252,29 @@ func GenECDSAToken(csp bccsp.BCCSP, cert []byte, key []byte, body []byte) (strin
 	if err != nil {
 		return "", err
 	}
+	return genECDSAToken(csp, cert, sk, body)
+}
+
+// CreateTokenWithKey creates a token like CreateToken, but signed with a
+// key which is held by the BCCSP, so that the private key is never exported
+// @param cert The pem-encoded certificate
+// @param key The BCCSP key associated with the certificate
+// @param body The body of an HTTP request
+func CreateTokenWithKey(csp bccsp.BCCSP, cert []byte, key bccsp.Key, body []byte) (string, error) {
+	x509Cert, err := GetX509CertificateFromPEM(cert)
+	if err != nil {
+		return "", err
+	}
+	switch x509Cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		return genECDSAToken(csp, cert, key, body)
+	default:
+		return "", errors.New("Tokens can only be created for certificates with ECDSA keys")
+	}
+}
+
+// genECDSAToken signs the http body and cert with the EC private key sk
+func genECDSAToken(csp bccsp.BCCSP, cert []byte, sk bccsp.Key, body []byte) (string, error) {
 
 	b64body := B64Encode(body)
 	b64cert := B64Encode(cert)
@@ -228,7 +287,7 @@ func GenECDSAToken(csp bccsp.BCCSP, cert []byte, key []byte, body []byte) (strin
 
 	ecSignature, signatureError := csp.Sign(sk, digest, nil)
 	if signatureError != nil {
-		return "", fmt.Errorf("BCCSP signature generation failed with error :%s", err)
+		return "", fmt.Errorf("BCCSP signature generation failed with error :%s", signatureError)
 	}
 	if len(ecSignature) == 0 {
 		return "", errors.New("BCCSP signature creation failed. Signature must be different than nil")
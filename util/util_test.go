@@ -341,6 +341,25 @@ func TestWriteFile(t *testing.T) {
 	os.Remove("../testdata/test.txt")
 }
 
+func TestWriteFileAtomically(t *testing.T) {
+	file := "../testdata/test.txt"
+	defer os.Remove(file)
+	for _, data := range []string{"foo", "bar"} {
+		err := WriteFileAtomically(file, []byte(data), 0600)
+		if err != nil {
+			t.Fatalf("Failed to write file atomically: %s", err)
+		}
+		buf, err := ioutil.ReadFile(file)
+		if err != nil || string(buf) != data {
+			t.Errorf("Expected file contents %q but found %q: %v", data, buf, err)
+		}
+	}
+	err := WriteFileAtomically("../testdata/bogus/test.txt", []byte("foo"), 0600)
+	if err == nil {
+		t.Error("Writing a file to a missing directory should have failed")
+	}
+}
+
 func getPath(file string) string {
 	return "../testdata/" + file
 }